@@ -0,0 +1,66 @@
+// stresstest hits a component from many goroutines at once, the tests using it are meant to be run with -race
+package stresstest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// runs client rounds times in each of clients goroutines (c is the client, i the round)
+// while every one of loops is run over and over, it returns once the clients are done and the loops stopped
+func Run(clients int, rounds int, client func(c int, i int), loops ...func()) {
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	for _, fn := range loops {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					fn()
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				client(c, i)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	running.Wait()
+}
+
+// sends the request and fails the test unless the answer has one of the wanted status codes
+func Do(t *testing.T, method string, url string, body []byte, want ...int) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Errorf("%s %s: %v", method, url, err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, url, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !slices.Contains(want, resp.StatusCode) {
+		t.Errorf("%s %s answered %d, want one of %v", method, url, resp.StatusCode, want)
+	}
+}
@@ -2,48 +2,77 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-collections/collections/queue"
-	"github.com/google/uuid"
 
 	"github.com/hanshal101/core/manager"
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/store"
 	"github.com/hanshal101/core/task"
 	"github.com/hanshal101/core/worker"
 )
 
-func main() {
-	whost := "0.0.0.0"
-	wport := 50051
-
-	mhost := "0.0.0.0"
-	mport := 50050
-
-	fmt.Println("starting core worker")
+// the state is kept in etcd when CORE_ETCD_ENDPOINTS (comma separated) is set, otherwise on the local disk
+func openStore(name string) (store.Store, error) {
+	if endpoints := os.Getenv("CORE_ETCD_ENDPOINTS"); endpoints != "" {
+		return store.NewEtcd(strings.Split(endpoints, ","), fmt.Sprintf("/core/%s", name))
+	}
+	return store.NewDisk(fmt.Sprintf("core-data/%s", name))
+}
 
-	w := worker.Worker{
-		Queue: *queue.New(),
-		DB:    make(map[uuid.UUID]*task.Task),
+func getenv(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return fallback
+}
 
-	wapi := worker.API{
-		Address: whost,
-		Port:    wport,
-		Worker:  &w,
-		Router:  gin.Default(),
+// the address (host:port) under which the others can reach this component, it is bound on every address
+// but 0.0.0.0 only works on the same host, so the hostname is used unless key says otherwise
+func advertise(key string, port int) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "127.0.0.1"
 	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
 
-	go w.RunTasks()
-	go w.CollectStats()
-	go wapi.Start()
+func main() {
+	whost := "0.0.0.0"
+	wport, _ := strconv.Atoi(getenv("CORE_WORKER_PORT", "50051"))
 
-	fmt.Println("Sleeping for 10 seconds to start the worker api")
-	time.Sleep(10 * time.Second)
+	mhost := "0.0.0.0"
+	mport, _ := strconv.Atoi(getenv("CORE_MANAGER_PORT", "50050"))
+	// the address the other replicas send the clients to while this replica leads, e.g. 10.0.0.10:50050
+	maddress := advertise("CORE_MANAGER_ADVERTISE", mport)
+	// addresses of all the manager replicas, comma separated
+	managers := strings.Split(getenv("CORE_MANAGERS", maddress), ",")
+	// how many tasks the manager sends and the worker runs at the same time
+	concurrency, _ := strconv.Atoi(getenv("CORE_MAX_CONCURRENCY", "10"))
 
 	fmt.Println("Starting core manager")
-	workers := []string{fmt.Sprintf("%s:%d", whost, wport)}
-	m := manager.New(workers)
+	ms, err := openStore("manager")
+	if err != nil {
+		log.Fatalf("Error in opening the manager store: %v", err)
+	}
+	m := manager.New([]string{}, scheduler.EpvmType, ms)
+	m.MaxConcurrency = concurrency
+	if es, ok := ms.(*store.Etcd); ok {
+		// the state is shared with the other replicas, it is loaded once this replica is elected
+		// its writes are fenced by the election, so a replica which lost the leadership can't overwrite the new leader
+		m.Elector = manager.NewEtcdElector(es, maddress)
+		go m.Lead()
+	} else if err := m.Restore(); err != nil {
+		log.Fatalf("Error in restoring the manager state: %v", err)
+	}
 
 	mapi := manager.API{
 		Address: mhost,
@@ -54,8 +83,66 @@ func main() {
 
 	go m.ProcessTasks()
 	go m.UpdateTasks()
+	go m.UpdateNodes()
 	go m.DoHealthChecks()
-	mapi.Start()
+	go m.DoHeartbeatChecks()
+	go mapi.Start()
+
+	fmt.Println("starting core worker")
+
+	name, err := os.Hostname()
+	if err != nil {
+		name = fmt.Sprintf("worker-%d", wport)
+	}
+
+	ws, err := openStore(fmt.Sprintf("workers/%s", name))
+	if err != nil {
+		log.Fatalf("Error in opening the worker store: %v", err)
+	}
+
+	rt, err := task.NewDocker()
+	if err != nil {
+		log.Fatalf("Error in connecting to docker: %v", err)
+	}
+	// only turn this on when the storage driver of docker supports a size per container
+	rt.StorageQuota = os.Getenv("CORE_DOCKER_STORAGE_QUOTA") == "true"
+
+	// the manager reaches the worker under this address, e.g. 10.0.0.12:50051
+	w := worker.New(name, advertise("CORE_WORKER_ADVERTISE", wport), managers, ws, rt)
+	w.Runtimes[task.RuntimeDocker] = rt
+	// tasks can also run as plain processes, confined by cgroups when CORE_CGROUP_ROOT is set
+	proc, err := task.NewProcess(fmt.Sprintf("core-data/processes/%s", name), os.Getenv("CORE_CGROUP_ROOT"))
+	if err != nil {
+		log.Fatalf("Error in setting up the process runtime: %v", err)
+	}
+	w.Runtimes[task.RuntimeProcess] = proc
+	w.MaxConcurrency = concurrency
+	// the host ports for the tasks without a fixed one, e.g. 30000-32767
+	if r := os.Getenv("CORE_PORT_RANGE"); r != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(r, "%d-%d", &start, &end); err != nil || start <= 0 || end < start {
+			log.Fatalf("Invalid port range %q", r)
+		}
+		w.Ports = worker.NewPortAllocator(start, end)
+	}
+	if err := w.Restore(); err != nil {
+		log.Fatalf("Error in restoring the worker state: %v", err)
+	}
+	w.Reconcile()
+
+	wapi := worker.API{
+		Address: whost,
+		Port:    wport,
+		Worker:  w,
+		Router:  gin.Default(),
+	}
+
+	go w.RunTasks()
+	go w.UpdateTasks()
+	go w.CollectStats()
+	go w.CheckHealth()
+	go w.SendHeartbeats(10 * time.Second)
+	wapi.Start()
 
 	// println("Sleeping")
 	// time.Sleep(15 * time.Second)
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/hanshal101/core/store"
+)
+
+// several manager replicas can run at the same time but only the leader does the work
+// (sending tasks, updating them, health checks), the followers redirect the api writes to the leader
+type Elector interface {
+	// keeps taking part in the election, elected is called every time this replica becomes the leader
+	// and before IsLeader starts returning true
+	Run(elected func())
+	IsLeader() bool
+	// api address (host:port) of the current leader, empty if there is none
+	Leader() string
+}
+
+// used when there is only a single manager, it is always the leader
+type Standalone struct {
+	Address string
+}
+
+func (s *Standalone) Run(elected func()) {}
+
+func (s *Standalone) IsLeader() bool {
+	return true
+}
+
+func (s *Standalone) Leader() string {
+	return s.Address
+}
+
+// leader election on top of etcd
+// every replica campaigns with a lease of TTL seconds, the one holding the election key is the leader
+// if the leader dies its lease expires and one of the followers takes over within TTL seconds
+// the leader fences the writes to Store with its election key, so a leader which was deposed
+// (but doesn't know it yet) can't write anymore, it stops leading as soon as its session ends or it
+// observes another leader
+type EtcdElector struct {
+	Store   *store.Etcd
+	Prefix  string
+	Address string
+	TTL     int
+	leader  atomic.Bool
+	key     atomic.Value
+	current atomic.Value
+}
+
+func NewEtcdElector(s *store.Etcd, address string) *EtcdElector {
+	e := &EtcdElector{
+		Store:   s,
+		Prefix:  "/core/election",
+		Address: address,
+		TTL:     5,
+	}
+	e.key.Store("")
+	e.current.Store("")
+	return e
+}
+
+func (e *EtcdElector) Run(elected func()) {
+	for {
+		session, err := concurrency.NewSession(e.Store.Client, concurrency.WithTTL(e.TTL))
+		if err != nil {
+			log.Printf("Error in creating election session: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		election := concurrency.NewElection(session, e.Prefix)
+		ctx, cancel := context.WithCancel(context.Background())
+		lost := make(chan struct{})
+		go e.observe(ctx, election, lost)
+		// stop campaigning as soon as our lease is gone
+		go func() {
+			select {
+			case <-session.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := election.Campaign(ctx, e.Address); err != nil {
+			log.Printf("Error in campaigning for leadership: %v\n", err)
+			cancel()
+			session.Close()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		// nothing is written before the fence is up
+		e.Store.Fence(election.Key(), election.Rev())
+		e.key.Store(election.Key())
+		log.Printf("Manager %s has been elected as leader\n", e.Address)
+		elected()
+		e.leader.Store(true)
+
+		select {
+		case <-session.Done():
+		case <-lost:
+		}
+		e.leader.Store(false)
+		log.Printf("Manager %s lost the leadership\n", e.Address)
+		cancel()
+		// closing the session revokes its lease, which takes our election key with it if it is still there
+		session.Close()
+	}
+}
+
+// keeps track of the current leader, lost is closed once someone else leads while we think we do,
+// or once we can't tell anymore because observing failed
+func (e *EtcdElector) observe(ctx context.Context, election *concurrency.Election, lost chan struct{}) {
+	var once sync.Once
+	for resp := range election.Observe(ctx) {
+		if len(resp.Kvs) == 0 {
+			continue
+		}
+		e.current.Store(string(resp.Kvs[0].Value))
+		if e.leader.Load() && string(resp.Kvs[0].Key) != e.key.Load().(string) {
+			log.Printf("Manager %s observed %s as the leader\n", e.Address, resp.Kvs[0].Value)
+			once.Do(func() { close(lost) })
+		}
+	}
+	if ctx.Err() == nil {
+		once.Do(func() { close(lost) })
+	}
+}
+
+func (e *EtcdElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *EtcdElector) Leader() string {
+	if e.IsLeader() {
+		return e.Address
+	}
+	return e.current.Load().(string)
+}
@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanshal101/core/internal/etcdtest"
+	"github.com/hanshal101/core/store"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEtcdElectorFencesDeposedLeader(t *testing.T) {
+	c := etcdtest.Start(t)
+	s1 := store.NewEtcdFromClient(c, "/core/manager")
+	s2 := store.NewEtcdFromClient(c, "/core/manager")
+	e1 := NewEtcdElector(s1, "m1:50050")
+	e2 := NewEtcdElector(s2, "m2:50050")
+
+	go e1.Run(func() {})
+	waitFor(t, "m1 to lead", e1.IsLeader)
+	go e2.Run(func() {})
+	waitFor(t, "m2 to see m1 as the leader", func() bool { return e2.Leader() == "m1:50050" })
+	if err := s1.Put(tasksBucket, "a", "m1"); err != nil {
+		t.Fatalf("write of the leader failed: %v", err)
+	}
+	if e2.IsLeader() {
+		t.Fatal("both replicas lead")
+	}
+
+	// m1 is cut off from etcd long enough for its election key to go, while its session is still alive
+	if _, err := c.Delete(context.Background(), e1.key.Load().(string)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.Put(tasksBucket, "a", "m1 again"); err != store.ErrFenced {
+		t.Errorf("write of the deposed leader = %v, want ErrFenced", err)
+	}
+
+	waitFor(t, "m2 to take over", e2.IsLeader)
+	waitFor(t, "m1 to notice it lost", func() bool { return !e1.IsLeader() })
+	if err := s2.Put(tasksBucket, "a", "m2"); err != nil {
+		t.Fatalf("write of the new leader failed: %v", err)
+	}
+	if err := s1.Put(tasksBucket, "a", "m1 once more"); err != store.ErrFenced {
+		t.Errorf("write of the old leader after the failover = %v, want ErrFenced", err)
+	}
+	var got string
+	if err := s2.Get(tasksBucket, "a", &got); err != nil || got != "m2" {
+		t.Errorf("task a = %q, %v, want the write of m2", got, err)
+	}
+}
@@ -6,35 +6,65 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
-	"strings"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/docker/go-connections/nat"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/store"
 	"github.com/hanshal101/core/task"
 	"github.com/hanshal101/core/worker"
 )
 
 // this is the manager model
-// it will take all the requests from the api in a form of queue(FIFO)
-// then two in-memory DB for storing the task and their events
-// to make sure the manager knows all the workers in the cluster, hence storing it in an array
-// mapping tasks to make the life of the manager easier for locating the task and managing their lifecycle
+// it takes the tasks from the api, picks a worker for each with the scheduler and keeps track of them on the workers
 type Manager struct {
-	Pending       queue.Queue
+	// the api and the loops run in their own goroutines, so all of the state below is guarded by mu
+	// the unexported helpers expect it to be held by the caller, it is never held while talking to a worker
+	mu sync.RWMutex
+	// every enqueue signals notify, so the pending queue is drained as soon as there is work
+	// at most MaxConcurrency tasks are sent at once, the events of a single task keep their order
+	notify         chan struct{}
+	MaxConcurrency int
+	Pending        queue.Queue
+	// the tasks and their events, written through to Store so that a restart doesn't lose them
 	TaskDB        map[uuid.UUID]*task.Task
 	EventDB       map[uuid.UUID]*task.TaskEvent
 	Workers       []string
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
-	LastWorker    int
+	// every worker is also a node, which is what the scheduler picks from
+	WorkerNodes []*node.Node
+	Volumes     map[string]*PersistentVolume
+	Scheduler   scheduler.Scheduler
+	Store       store.Store
+	// with several replicas only the leader runs the loops, the others wait to take over
+	Elector Elector
+	// a worker which stays silent for HeartbeatTimeout is marked unhealthy, after WorkerGracePeriod
+	// its tasks are moved to other workers and after WorkerRemoveTimeout it is removed from the cluster
+	HeartbeatTimeout    time.Duration
+	WorkerGracePeriod   time.Duration
+	WorkerRemoveTimeout time.Duration
+	// the backoff before a restart starts at RestartBackoff and doubles with every restart up to MaxRestartBackoff
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	restartAt         map[uuid.UUID]time.Time
+	// picks where between half and all of the backoff the restart happens
+	jitter func(n int64) int64
 }
 
 func (m *Manager) GetTasks() []task.Task {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	tasks := make([]task.Task, 0, len(m.TaskDB))
 	for _, t := range m.TaskDB {
 		tasks = append(tasks, *t)
@@ -43,242 +73,865 @@ func (m *Manager) GetTasks() []task.Task {
 	return tasks
 }
 
-func (m *Manager) SelectWorker() string {
-	fmt.Println("This will select the best worker")
-	var wrkr int
-	if m.LastWorker == len(m.Workers)-1 {
-		wrkr = 0
-		m.LastWorker = 0
-	} else {
-		wrkr = m.LastWorker + 1
-		m.LastWorker++
+func (m *Manager) GetTask(id uuid.UUID) (task.Task, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.TaskDB[id]
+	if !ok {
+		return task.Task{}, false
 	}
-	return m.Workers[wrkr]
+	return *t, true
+}
+
+// asks the scheduler for the best worker for the task
+func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.selectWorker(t)
+}
+
+func (m *Manager) selectWorker(t task.Task) (*node.Node, error) {
+	nodes, err := m.volumeNodes(t, m.healthyNodes())
+	if err != nil {
+		return nil, err
+	}
+	candidates := m.Scheduler.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no available candidates match resource request for task %v", t.ID)
+	}
+
+	scores := m.Scheduler.Score(t, candidates)
+	selected := m.Scheduler.Pick(scores, candidates)
+	if selected == nil {
+		return nil, fmt.Errorf("scheduler could not pick a worker for task %v", t.ID)
+	}
+	return selected, nil
 }
 
 func (m *Manager) updateTasks() {
 	fmt.Println("This will update tasks")
-	for _, wrkr := range m.Workers {
-		url := fmt.Sprintf("http://%s/tasks", wrkr)
+	for _, n := range m.nodeAddresses() {
+		url := fmt.Sprintf("%s/tasks", n.Api)
 		resp, err := http.Get(url)
 		if err != nil {
-			log.Printf("Error in connecting to %v::%v\n", wrkr, err)
+			log.Printf("Error in connecting to %v::%v\n", n.Name, err)
+			continue
 		}
 		if resp.StatusCode != http.StatusOK {
 			log.Printf("Error in sending request (%d)::%v\n", resp.StatusCode, err)
+			resp.Body.Close()
+			continue
 		}
 		d := json.NewDecoder(resp.Body)
 		var tasks []*task.Task
-		if err := d.Decode(&tasks); err != nil {
+		err = d.Decode(&tasks)
+		resp.Body.Close()
+		if err != nil {
 			log.Printf("Error in decoding the response: Error: %v\n", err)
+			continue
 		}
+		// the worker answered, which is as good as a heartbeat
+		m.Heartbeat(n.Name)
 
-		for _, t := range tasks {
-			log.Printf("Updating Task :: %v\n", t)
-			_, ok := m.TaskDB[t.ID]
-			if !ok {
-				log.Printf("Task not present: TaskID:%v :: Task:%v", t.ID, t)
-				return
+		if !m.Elector.IsLeader() {
+			return
+		}
+		for _, t := range m.applyUpdates(n.Name, tasks) {
+			// it is still reported by the worker if this fails, so it is tried again with the next update
+			if err := m.stopTask(n.Api, task.TaskEvent{ID: uuid.New(), Timestamp: time.Now().UTC(), Task: t}); err != nil {
+				log.Printf("Error in stopping task %v on worker %s: %v\n", t.ID, n.Name, err)
+			}
+		}
+	}
+}
+
+// copies what the worker reported into the task db
+// returns the tasks which the worker should not be running anymore
+func (m *Manager) applyUpdates(worker string, tasks []*task.Task) []task.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stale []task.Task
+	for _, t := range tasks {
+		log.Printf("Updating Task :: %v\n", t)
+		_, ok := m.TaskDB[t.ID]
+		if !ok {
+			log.Printf("Task not present: TaskID:%v :: Task:%v", t.ID, t)
+			continue
+		}
+
+		// the worker came back after its tasks were moved to other workers, so the old copy has to go
+		if m.TaskWorkerMap[t.ID] != worker {
+			if t.State == task.Running || t.State == task.Scheduled {
+				log.Printf("Task %v is running on %s but belongs to %s, stopping it\n", t.ID, worker, m.TaskWorkerMap[t.ID])
+				stale = append(stale, *t)
 			}
+			continue
+		}
 
-			fmt.Println("st ----------------> ", m.TaskDB[t.ID].State, t.State)
-			if m.TaskDB[t.ID].State != t.State {
-				m.TaskDB[t.ID].State = t.State
+		fmt.Println("st ----------------> ", m.TaskDB[t.ID].State, t.State)
+		m.TaskDB[t.ID].MergeHistory(t.History)
+		if m.TaskDB[t.ID].State != t.State {
+			// the task is done, so the worker gets its resources back
+			if !finished(m.TaskDB[t.ID].State) && finished(t.State) {
+				if n := m.getNode(worker); n != nil {
+					release(n, *m.TaskDB[t.ID])
+				}
 			}
+			m.TaskDB[t.ID].SetState(t.State, fmt.Sprintf("reported by worker %s", worker))
+		}
 
-			m.TaskDB[t.ID].StartTime = t.StartTime
-			m.TaskDB[t.ID].EndTime = t.EndTime
-			m.TaskDB[t.ID].ContainerID = t.ContainerID
+		m.TaskDB[t.ID].StartTime = t.StartTime
+		m.TaskDB[t.ID].EndTime = t.EndTime
+		m.TaskDB[t.ID].ExitCode = t.ExitCode
+		m.TaskDB[t.ID].OOMKilled = t.OOMKilled
+		m.TaskDB[t.ID].Error = t.Error
+		m.TaskDB[t.ID].Health = t.Health
+		m.TaskDB[t.ID].HealthMessage = t.HealthMessage
+		m.TaskDB[t.ID].Ready = t.Ready
+		m.TaskDB[t.ID].ReadinessMessage = t.ReadinessMessage
+		m.TaskDB[t.ID].ContainerID = t.ContainerID
+		// the worker hands out the host ports, so the node only learns about them now
+		if !finished(t.State) && !reflect.DeepEqual(m.TaskDB[t.ID].HostPort, t.HostPort) {
+			m.TaskDB[t.ID].HostPort = t.HostPort
+			if n := m.getNode(worker); n != nil {
+				n.ReleasePorts(t.ID.String())
+				n.ReservePorts(t.ID.String(), scheduler.HostPorts(*m.TaskDB[t.ID]))
+			}
 		}
+		m.saveTask(m.TaskDB[t.ID])
 	}
+	return stale
+}
+
+// copies of the nodes, so that the workers can be called without holding the lock
+func (m *Manager) nodeAddresses() []node.Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes := make([]node.Node, 0, len(m.WorkerNodes))
+	for _, n := range m.WorkerNodes {
+		nodes = append(nodes, *n)
+	}
+	return nodes
+}
+
+func finished(s task.State) bool {
+	return s == task.Completed || s == task.Failed || s == task.Lost
 }
 
 func (m *Manager) SendWork() {
 	fmt.Println("This will send work to the workers")
+	m.mu.Lock()
 	if m.Pending.Len() > 0 {
-		w := m.SelectWorker()
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		m.mu.Unlock()
+		m.sendEvent(te)
+	} else {
+		m.mu.Unlock()
+		log.Println("No work in the queue")
+	}
+}
 
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
-		t := te.Task
-		log.Printf("Pulled %v off pending queue\n", t)
+// starts or stops the task of the event on its worker
+// returns false if the event was put back on the queue to be tried again later
+func (m *Manager) sendEvent(te task.TaskEvent) bool {
+	m.mu.Lock()
+	t := te.Task
+	log.Printf("Pulled %v off pending queue\n", t)
 
-		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], t.ID)
-		m.TaskWorkerMap[t.ID] = w
+	// the task is already running on a worker, so this is a request to stop it
+	// the event is only done once the worker took the stop, until then it is retried like a start
+	if w, ok := m.TaskWorkerMap[t.ID]; ok && te.State == task.Completed {
+		persisted := m.TaskDB[t.ID]
+		if !persisted.TryTransition(task.Stopping, "stop requested") {
+			log.Printf("Invalid request: existing task %v is in state %v and cannot be stopped\n", persisted.ID, persisted.State)
+			m.done(te)
+			m.mu.Unlock()
+			return true
+		}
+		// a leader which was deposed can't write anymore, so it doesn't stop the task either
+		if err := m.saveTask(persisted); err != nil || !m.Elector.IsLeader() {
+			m.mu.Unlock()
+			return true
+		}
+		n := m.getNode(w)
+		if n == nil {
+			log.Printf("Worker %s of task %v is not part of the cluster anymore\n", w, t.ID)
+			m.done(te)
+			m.mu.Unlock()
+			return true
+		}
+		m.mu.Unlock()
+		if err := m.stopTask(n.Api, te); err != nil {
+			log.Printf("Error in stopping task %v on worker %s: %v\n", t.ID, w, err)
+			m.mu.Lock()
+			m.Pending.Enqueue(te)
+			m.mu.Unlock()
+			return false
+		}
+		m.mu.Lock()
+		m.done(te)
+		m.mu.Unlock()
+		return true
+	}
+	if te.State == task.Completed {
+		log.Printf("Task %v is not running on any worker, nothing to stop\n", t.ID)
+		m.done(te)
+		m.mu.Unlock()
+		return true
+	}
+
+	if !task.ValidStateTransitions(t.State, task.Scheduled) {
+		log.Printf("Invalid request: task %v is in state %v and cannot be scheduled\n", t.ID, t.State)
+		m.done(te)
+		m.mu.Unlock()
+		return true
+	}
+	n, err := m.selectWorker(t)
+	if err != nil {
+		log.Printf("Error in selecting worker for task %v: %v\n", t.ID, err)
+		m.Pending.Enqueue(te)
+		m.mu.Unlock()
+		return false
+	}
+	w := n.Name
+	api := n.Api
+	t.Mounts = m.claimVolumes(t, w)
+	te.Task.Mounts = t.Mounts
+
+	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], t.ID)
+	m.TaskWorkerMap[t.ID] = w
+	allocate(n, t)
 
-		if te.Task.State != task.Completed {
-			te.Task.State = task.Scheduled
+	t.TryTransition(task.Scheduled, fmt.Sprintf("placed on worker %s", w))
+	te.Task = t
+
+	m.TaskDB[t.ID] = &t
+	m.EventDB[te.ID] = &te
+	m.saveTask(&t)
+	m.saveEvent(&te)
+	// the task only goes out once its assignment is stored, otherwise the next leader would send it again
+	// a leader which was deposed can't write anymore, so it never sends the task
+	if err := m.saveAssignment(t.ID, w); err != nil || !m.Elector.IsLeader() {
+		m.unassign(w, t)
+		m.Pending.Enqueue(te)
+		m.mu.Unlock()
+		return false
+	}
+	m.mu.Unlock()
+
+	data, err := json.Marshal(te)
+	if err != nil {
+		log.Printf("Error in json marshal: Error: %v :: TaskEvent: %v\n", err, te)
+	}
+
+	url := fmt.Sprintf("%s/tasks", api)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error in sending request to worker: Error: %v :: Url: %s\n", err, url)
+		m.mu.Lock()
+		m.unassign(w, t)
+		m.Pending.Enqueue(te)
+		m.mu.Unlock()
+		return false
+	}
+	defer resp.Body.Close()
+
+	m.mu.Lock()
+	m.done(te)
+	// the worker took the task, so the volumes it bound stay with the worker from now on
+	if resp.StatusCode == http.StatusCreated {
+		m.keepVolumes(t.ID)
+	} else {
+		m.unbindVolumes(t.ID)
+	}
+	m.mu.Unlock()
+
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrResponse{}
+		if err := d.Decode(&e); err != nil {
+			fmt.Printf("Error in decoding resposne: Error: %v\n", err)
+			return true
 		}
+		log.Printf("Response error (%d): %s\n", e.HTTPStatusCode, e.Message)
+		return true
+	}
+	// t = task.Task{}
+	// if err := d.Decode(&t); err != nil {
+	// 	fmt.Printf("Error in decoding response: Error: %s", err)
+	// 	return
+	// }
+	// log.Printf("%#v\n", t)
+	return true
+}
 
-		m.TaskDB[t.ID] = &t
-		m.EventDB[te.ID] = &te
+// sends the stop event of the task to the worker at api, the worker stops it like it starts it
+// an error means the worker didn't take the stop, so it has to be sent again
+func (m *Manager) stopTask(api string, te task.TaskEvent) error {
+	te.State = task.Completed
+	te.Task.State = task.Completed
+	data, err := json.Marshal(te)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of %v: %v", te, err)
+	}
 
-		data, err := json.Marshal(te)
-		if err != nil {
-			log.Printf("Error in json marshal: Error: %v :: TaskEvent: %v\n", err, te)
+	url := fmt.Sprintf("%s/tasks", api)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error in connecting to worker at %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return fmt.Errorf("worker answered %d", resp.StatusCode)
 		}
+		return fmt.Errorf("worker answered %d: %s", e.HTTPStatusCode, e.Message)
+	}
 
-		url := fmt.Sprintf("http://%s/tasks", w)
-		resp, err := http.Post(url, "application/json", bytes.NewReader(data))
-		if err != nil {
-			log.Printf("Error in sending request to worker: Error: %v :: Url: %s\n", err, url)
-			m.Pending.Enqueue(te)
-			return
+	log.Printf("Task %v has been scheduled to be stopped", te.Task.ID)
+	return nil
+}
+
+func (m *Manager) getNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
 		}
+	}
+	return nil
+}
 
-		d := json.NewDecoder(resp.Body)
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrResponse{}
-			if err := d.Decode(&e); err != nil {
-				fmt.Printf("Error in decoding resposne: Error: %v\n", err)
-				return
-			}
-			log.Printf("Response error (%d): %s\n", e.HTTPStatusCode, e.Message)
-			return
+// removes the task from the worker it was assigned to, used when the task never made it to the worker
+func (m *Manager) unassign(w string, t task.Task) {
+	ids := m.WorkerTaskMap[w]
+	for i, id := range ids {
+		if id == t.ID {
+			m.WorkerTaskMap[w] = append(ids[:i], ids[i+1:]...)
+			break
 		}
-		// t = task.Task{}
-		// if err := d.Decode(&t); err != nil {
-		// 	fmt.Printf("Error in decoding response: Error: %s", err)
-		// 	return
-		// }
-		// log.Printf("%#v\n", t)
-	} else {
-		log.Println("No work in the queue")
 	}
+	delete(m.TaskWorkerMap, t.ID)
+	m.deleteAssignment(t.ID)
+	m.unbindVolumes(t.ID)
+	if n := m.getNode(w); n != nil {
+		release(n, t)
+	}
+}
+
+// keeps the node counters in sync with the tasks placed on it
+func allocate(n *node.Node, t task.Task) {
+	n.CPUAllocated += t.CPU
+	n.MemoryAllocated += t.MemoryRequest()
+	n.DiskAllocated += t.Disk
+	n.TaskCount++
+	n.ReservePorts(t.ID.String(), scheduler.HostPorts(t))
+}
+
+func release(n *node.Node, t task.Task) {
+	n.CPUAllocated = max(n.CPUAllocated-t.CPU, 0)
+	n.MemoryAllocated = max(n.MemoryAllocated-t.MemoryRequest(), 0)
+	n.DiskAllocated = max(n.DiskAllocated-t.Disk, 0)
+	n.TaskCount = max(n.TaskCount-1, 0)
+	n.ReleasePorts(t.ID.String())
 }
 
 // Adding task
 func (m *Manager) AddTask(te task.TaskEvent) {
-	m.Pending.Enqueue(te)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if te.Timestamp.IsZero() {
+		te.Timestamp = time.Now().UTC()
+	}
+	m.enqueue(te)
 }
 
-func New(workers []string) *Manager {
+// workers is an optional list of worker addresses (host:port) known upfront, the address is used as the name of the worker
+// every other worker joins the cluster by registering itself
+// schedulerType is one of the scheduler types, if it is unknown round robin is used
+// s is where the state of the cluster is kept, if it is nil everything is kept in memory
+// call Restore afterwards to load the state which is already in the store
+func New(workers []string, schedulerType string, s store.Store) *Manager {
 	workerTaskMap := make(map[string][]uuid.UUID)
+	var nodes []*node.Node
 	for worker := range workers {
 		workerTaskMap[workers[worker]] = []uuid.UUID{}
+		n := node.NewNode(workers[worker], fmt.Sprintf("http://%s", workers[worker]), "worker")
+		n.IP, _, _ = net.SplitHostPort(workers[worker])
+		n.Healthy = true
+		n.LastHeartbeat = time.Now().UTC()
+		nodes = append(nodes, n)
+	}
+
+	sc, err := scheduler.New(schedulerType)
+	if err != nil {
+		log.Printf("%v, falling back to %s\n", err, scheduler.RoundRobinType)
+		sc = &scheduler.RoundRobin{Name: scheduler.RoundRobinType}
+	}
+
+	if s == nil {
+		s = store.NewMemory()
 	}
 
 	return &Manager{
-		Pending:       *queue.New(),
-		TaskDB:        make(map[uuid.UUID]*task.Task),
-		EventDB:       make(map[uuid.UUID]*task.TaskEvent),
-		Workers:       workers,
-		WorkerTaskMap: workerTaskMap,
-		TaskWorkerMap: make(map[uuid.UUID]string),
+		Pending:             *queue.New(),
+		TaskDB:              make(map[uuid.UUID]*task.Task),
+		EventDB:             make(map[uuid.UUID]*task.TaskEvent),
+		Workers:             workers,
+		WorkerTaskMap:       workerTaskMap,
+		TaskWorkerMap:       make(map[uuid.UUID]string),
+		WorkerNodes:         nodes,
+		Volumes:             make(map[string]*PersistentVolume),
+		Scheduler:           sc,
+		Store:               s,
+		Elector:             &Standalone{},
+		HeartbeatTimeout:    30 * time.Second,
+		WorkerGracePeriod:   time.Minute,
+		WorkerRemoveTimeout: 5 * time.Minute,
+		RestartBackoff:      DefaultRestartBackoff,
+		MaxRestartBackoff:   DefaultMaxRestartBackoff,
+		restartAt:           make(map[uuid.UUID]time.Time),
+		jitter:              rand.Int63n,
+		notify:              make(chan struct{}, 1),
+		MaxConcurrency:      10,
 	}
 }
 
-// Updating task
-func (m *Manager) UpdateTasks() {
+// wakes up ProcessTasks, a pending signal is enough so this never blocks
+func (m *Manager) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// refreshes every node with the stats reported by its worker
+func (m *Manager) updateNodes() {
+	for _, n := range m.nodeAddresses() {
+		url := fmt.Sprintf("%s/stats", n.Api)
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Printf("Error in connecting to %v::%v\n", n.Name, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("Error in getting stats from %v (%d)\n", n.Name, resp.StatusCode)
+			resp.Body.Close()
+			continue
+		}
+
+		var stats *worker.Stats
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("Error in decoding the stats of %v: %v\n", n.Name, err)
+			continue
+		}
+		// the worker has not collected its stats yet
+		if stats == nil {
+			continue
+		}
+		m.applyStats(n.Name, stats)
+	}
+}
+
+func (m *Manager) applyStats(name string, stats *worker.Stats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.getNode(name)
+	if n == nil {
+		return
+	}
+
+	// the task count and the allocations are kept by allocate and release, they include the tasks
+	// which are placed on the worker but don't run yet
+	n.RunningTasks = stats.TaskCount
+	if stats.MemoryStats != nil {
+		// meminfo is in kB while the node keeps bytes
+		n.MemoryCapacity = int(stats.TotalMemory() * 1024)
+		n.MemoryUsed = int(stats.MemoryUsed() * 1024)
+	}
+	if stats.DiskStats != nil {
+		n.DiskCapacity = int(stats.DiskTotal())
+		n.DiskUsed = int(stats.DiskUsed())
+	}
+	// a worker which was listed upfront never registered, so its stats are all there is to know its capacity
+	if n.Cores == 0 {
+		n.Cores = stats.Cores
+	}
+	if n.Memory == 0 {
+		n.Memory = n.MemoryCapacity
+	}
+	if n.Disk == 0 {
+		n.Disk = n.DiskCapacity
+	}
+	if stats.CPUStats != nil {
+		n.CPUUsage = stats.CpuUsage()
+	}
+	if stats.LoadStats != nil {
+		n.Load = stats.LoadStats.Last1Min
+	}
+	n.LastUpdated = time.Now().UTC()
+}
+
+func (m *Manager) GetNodes() []node.Node {
+	return m.nodeAddresses()
+}
+
+func (m *Manager) GetNode(name string) (node.Node, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := m.getNode(name)
+	if n == nil {
+		return node.Node{}, false
+	}
+	return *n, true
+}
+
+// Updating nodes
+func (m *Manager) UpdateNodes() {
 	for {
-		log.Println("Checking tasks for updates from workers!")
-		m.updateTasks()
-		log.Println("Tasks updates completed!")
+		if !m.Elector.IsLeader() {
+			time.Sleep(time.Second)
+			continue
+		}
+		log.Println("Collecting stats from workers!")
+		m.updateNodes()
+		log.Println("Node updates completed!")
 		log.Println("Sleeping for 15 seconds!")
 		time.Sleep(15 * time.Second)
 	}
 }
 
-// Process tasks
-func (m *Manager) ProcessTasks() {
-	for {
-		log.Println("Processing tasks!")
-		m.SendWork()
-		log.Println("Tasks processed!")
-		log.Println("Sleeping for 10 seconds!")
-		time.Sleep(10 * time.Second)
+// adds the worker to the cluster, a worker which is already known just gets its details refreshed
+func (m *Manager) RegisterWorker(r worker.Registration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registerWorker(r)
+}
+
+func (m *Manager) registerWorker(r worker.Registration) {
+	n := m.getNode(r.Name)
+	if n == nil {
+		n = node.NewNode(r.Name, fmt.Sprintf("http://%s", r.Address), "worker")
+		m.Workers = append(m.Workers, r.Name)
+		m.WorkerNodes = append(m.WorkerNodes, n)
+		m.WorkerTaskMap[r.Name] = []uuid.UUID{}
+		log.Printf("Worker %s registered with address %s\n", r.Name, r.Address)
+	} else {
+		n.Api = fmt.Sprintf("http://%s", r.Address)
+		log.Printf("Worker %s registered again with address %s\n", r.Name, r.Address)
 	}
+
+	n.IP, _, _ = net.SplitHostPort(r.Address)
+	n.Cores = r.Cores
+	n.Memory = r.Memory
+	n.Disk = r.Disk
+	n.PortRangeStart = r.PortRangeStart
+	n.PortRangeEnd = r.PortRangeEnd
+	n.Healthy = true
+	n.LastHeartbeat = time.Now().UTC()
+	m.saveWorker(r)
+	// the new worker may have room for the tasks which are still waiting
+	m.signal()
 }
 
-// checking task health
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	log.Printf("Calling health check for task: %v\n", t)
+// records the heartbeat of the worker, an error means the worker is unknown and has to register again
+func (m *Manager) Heartbeat(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.getNode(name)
+	if n == nil {
+		return fmt.Errorf("worker %s is not registered", name)
+	}
+	if !n.Healthy {
+		log.Printf("Worker %s is healthy again\n", name)
+	}
+	n.Healthy = true
+	n.LastHeartbeat = time.Now().UTC()
+	return nil
+}
 
-	w := m.TaskWorkerMap[t.ID]
-	hostport := gethostport(t.HostPort)
+func (m *Manager) healthyNodes() []*node.Node {
+	var nodes []*node.Node
+	for _, n := range m.WorkerNodes {
+		if n.Healthy {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
 
-	// Check if hostport is nil
-	if hostport == nil {
-		msg := fmt.Sprintf("No valid host port found for task: %v", t)
-		log.Println(msg)
-		return errors.New(msg)
+// marks the workers which stopped sending heartbeats as unhealthy, and removes the ones which are gone for too long
+// once a worker is unreachable for longer than WorkerGracePeriod its tasks are considered lost and are scheduled again
+func (m *Manager) checkHeartbeats() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	// removing a worker changes the slice, so loop over a copy
+	nodes := append([]*node.Node{}, m.WorkerNodes...)
+	for _, n := range nodes {
+		silence := now.Sub(n.LastHeartbeat)
+		if silence > m.WorkerRemoveTimeout {
+			m.removeWorker(n.Name)
+			continue
+		}
+		if n.Healthy && silence > m.HeartbeatTimeout {
+			log.Printf("Worker %s missed its heartbeats for %v, marking it unhealthy\n", n.Name, silence)
+			n.Healthy = false
+			// nobody knows what the tasks are doing until the worker reports again
+			for _, id := range m.WorkerTaskMap[n.Name] {
+				if t, ok := m.TaskDB[id]; ok && !finished(t.State) {
+					t.TryTransition(task.Unknown, fmt.Sprintf("worker %s missed its heartbeats", n.Name))
+					m.saveTask(t)
+				}
+			}
+		}
+		if silence > m.WorkerGracePeriod && len(m.WorkerTaskMap[n.Name]) > 0 {
+			log.Printf("Worker %s is unreachable for %v, rescheduling its tasks\n", n.Name, silence)
+			m.rescheduleTasks(n.Name)
+		}
 	}
+}
 
-	worker := strings.Split(w, ":")
+// marks all the unfinished tasks of the worker as lost and puts them back on the pending queue
+func (m *Manager) rescheduleTasks(name string) {
+	n := m.getNode(name)
+	for _, id := range m.WorkerTaskMap[name] {
+		delete(m.TaskWorkerMap, id)
+		m.deleteAssignment(id)
 
-	// Construct the URL safely
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostport, t.HealthCheck) // Dereference safely
+		t, ok := m.TaskDB[id]
+		if !ok || finished(t.State) {
+			continue
+		}
+		if n != nil {
+			release(n, *t)
+		}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		msg := fmt.Sprintf("Error in health check: %v\n", err)
-		log.Println(msg)
-		return errors.New(msg)
+		t.TryTransition(task.Lost, fmt.Sprintf("worker %s is unreachable", name))
+		m.saveTask(t)
+		log.Printf("Task %v on worker %s is lost\n", t.ID, name)
+
+		taskCopy := *t
+		taskCopy.ContainerID = ""
+		taskCopy.HostPort = nil
+		te := task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now().UTC(),
+			Task:      taskCopy,
+		}
+		m.enqueue(te)
 	}
+	m.WorkerTaskMap[name] = []uuid.UUID{}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		msg := fmt.Sprintf("Health check failed: %v\n", resp.StatusCode)
-		log.Println(msg)
-		return errors.New(msg)
+func (m *Manager) removeWorker(name string) {
+	log.Printf("Removing worker %s from the cluster\n", name)
+	m.rescheduleTasks(name)
+	for i, w := range m.Workers {
+		if w == name {
+			m.Workers = append(m.Workers[:i], m.Workers[i+1:]...)
+			break
+		}
 	}
+	for i, n := range m.WorkerNodes {
+		if n.Name == name {
+			m.WorkerNodes = append(m.WorkerNodes[:i], m.WorkerNodes[i+1:]...)
+			break
+		}
+	}
+	delete(m.WorkerTaskMap, name)
+	m.deleteWorker(name)
+}
 
-	log.Printf("Health check passed for task: %v : With response: %v\n", t, resp)
-	return nil
+func (m *Manager) DoHeartbeatChecks() {
+	for {
+		if !m.Elector.IsLeader() {
+			time.Sleep(time.Second)
+			continue
+		}
+		log.Println("Checking worker heartbeats")
+		m.checkHeartbeats()
+		log.Println("Worker heartbeat checks completed")
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
 }
 
-func gethostport(ports nat.PortMap) *string {
-	for k, _ := range ports {
-		// Ensure that we are returning a valid port
-		if len(ports[k]) > 0 {
-			return &ports[k][0].HostPort
+// Updating task
+func (m *Manager) UpdateTasks() {
+	for {
+		if !m.Elector.IsLeader() {
+			time.Sleep(time.Second)
+			continue
 		}
+		log.Println("Checking tasks for updates from workers!")
+		m.updateTasks()
+		log.Println("Tasks updates completed!")
+		log.Println("Sleeping for 15 seconds!")
+		time.Sleep(15 * time.Second)
 	}
-	return nil // Return nil if no valid ports are found
 }
 
-// This will to all the health checks for the tasks
-// Flow: 1. Call the manager’s checkTaskHealth method, which in turn will call the task’s health check endpoint
-//  2. If the task’s health check fails, attempt to restart the task
-//  3. If the task is in Failed state: attempt to restart the task
-func (m *Manager) doHelathChecks() {
-	for _, t := range m.TaskDB {
-		if t.State == task.Running && t.RestartCount < 3 {
-			if err := m.checkTaskHealth(*t); err != nil {
-				if t.RestartCount < 3 {
-					m.restartTask(t)
+// Process tasks
+// runs whenever tasks are added, the ticker retries the tasks which couldn't be placed on a worker
+func (m *Manager) ProcessTasks() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.notify:
+		case <-ticker.C:
+		}
+		if !m.Elector.IsLeader() {
+			continue
+		}
+		log.Println("Processing tasks!")
+		m.processBatch()
+		log.Println("Tasks processed!")
+	}
+}
+
+// sends every event which is pending right now, MaxConcurrency tasks at a time
+// the events of a task are sent one after the other in the order they were added, so a stop can't overtake the start
+// once an event of a task is put back on the queue its later events go back behind it and wait for the next batch
+func (m *Manager) processBatch() {
+	m.mu.Lock()
+	var order []uuid.UUID
+	events := map[uuid.UUID][]task.TaskEvent{}
+	for m.Pending.Len() > 0 {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		if _, ok := events[te.Task.ID]; !ok {
+			order = append(order, te.Task.ID)
+		}
+		events[te.Task.ID] = append(events[te.Task.ID], te)
+	}
+	m.mu.Unlock()
+
+	sem := make(chan struct{}, max(m.MaxConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, id := range order {
+		sem <- struct{}{}
+		// the leadership was lost in the middle of the batch, the events which weren't sent stay pending
+		if !m.Elector.IsLeader() {
+			<-sem
+			m.mu.Lock()
+			for _, id := range order[i:] {
+				for _, te := range events[id] {
+					m.Pending.Enqueue(te)
 				}
-			} else if t.State == task.Failed && t.RestartCount < 3 {
-				m.restartTask(t)
 			}
+			m.mu.Unlock()
+			break
 		}
+		wg.Add(1)
+		go func(tes []task.TaskEvent) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for j, te := range tes {
+				if !m.sendEvent(te) {
+					m.mu.Lock()
+					for _, te := range tes[j+1:] {
+						m.Pending.Enqueue(te)
+					}
+					m.mu.Unlock()
+					return
+				}
+			}
+		}(events[id])
+	}
+	wg.Wait()
+}
+
+// This will to all the health checks for the tasks
+// Flow: 1. The workers run the health checks of their tasks and report the health along with the state of the task
+//  2. If a task is unhealthy, restart the task on its worker once its backoff is over, if its restart policy allows it
+//  3. If the task has completed or failed: restart it according to its restart policy
+func (m *Manager) doHelathChecks() {
+	// work on copies, so that the lock isn't held while restarting the tasks
+	for _, t := range m.GetTasks() {
+		if t.State != task.Running {
+			continue
+		}
+		if m.unhealthyRestartDue(t) {
+			m.restartTask(t.ID, fmt.Sprintf("health check failed: %s", t.HealthMessage))
+		}
+	}
+	m.restartTasks()
+}
+
+// a failing health check counts as a failure of the task, the backoff is counted from when the task was started
+func (m *Manager) unhealthyRestartDue(t task.Task) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failed := t
+	failed.State = task.Failed
+	if t.Health != task.HealthUnhealthy || !failed.ShouldRestart() {
+		delete(m.restartAt, t.ID)
+		return false
 	}
+	return !time.Now().UTC().Before(m.restartDue(&t, t.StartTime))
 }
 
-// this will restart the task
-func (m *Manager) restartTask(t *task.Task) {
+// this will restart the task on its worker, reason ends up in the history of the task
+func (m *Manager) restartTask(id uuid.UUID, reason string) {
+	m.mu.Lock()
+	t, ok := m.TaskDB[id]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
 	w := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
+	if !t.TryTransition(task.Restarting, reason) {
+		m.mu.Unlock()
+		return
+	}
 	t.RestartCount++
-	m.TaskDB[t.ID] = t
+	if err := m.saveTask(t); err != nil || !m.Elector.IsLeader() {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.restartAt, t.ID)
 
 	te := task.TaskEvent{
-		ID:    uuid.New(),
-		State: task.Running,
-		Task:  *t,
+		ID:        uuid.New(),
+		State:     task.Restarting,
+		Timestamp: time.Now().UTC(),
+		Task:      *t,
 	}
 
+	n := m.getNode(w)
+	if n == nil {
+		log.Printf("Worker %v of task %v is not part of the cluster anymore\n", w, t.ID)
+		m.mu.Unlock()
+		return
+	}
+	api := n.Api
+	m.mu.Unlock()
+
 	data, err := json.Marshal(te)
 	if err != nil {
 		log.Printf("Unable to marshal the task event: %v\n", err)
 	}
 
-	url := fmt.Sprintf("http://%v/tasks", w)
+	url := fmt.Sprintf("%s/tasks", api)
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		log.Printf("Error connecting to %v : Error: %v", w, err)
-		m.Pending.Enqueue(t)
+		// let the scheduler find another worker for it
+		m.mu.Lock()
+		m.unassign(w, te.Task)
+		te.State = task.Scheduled
+		te.Timestamp = time.Now().UTC()
+		m.enqueue(te)
+		m.mu.Unlock()
 		return
 	}
+	defer resp.Body.Close()
 
 	d := json.NewDecoder(resp.Body)
 	if resp.StatusCode != http.StatusCreated {
@@ -290,11 +943,15 @@ func (m *Manager) restartTask(t *task.Task) {
 		log.Printf("Response error : (%d): %v", e.HTTPStatusCode, e.Message)
 		return
 	}
-	log.Printf("%#v\n", t)
+	log.Printf("%#v\n", te.Task)
 }
 
 func (m *Manager) DoHealthChecks() {
 	for {
+		if !m.Elector.IsLeader() {
+			time.Sleep(time.Second)
+			continue
+		}
 		log.Println("Performing task health check")
 		m.doHelathChecks()
 		log.Println("Task health checks completed")
@@ -337,13 +994,33 @@ func (a *API) StartTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: msg})
 		return
 	}
+	if err := te.Task.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
 
 	a.Manager.AddTask(te)
 	c.Status(http.StatusCreated)
 }
 
+// with ?ready=true only the tasks which can take traffic are listed, e.g. for a load balancer
 func (a *API) GetTasks(c *gin.Context) {
 	tasks := a.Manager.GetTasks()
+	if ready := c.Query("ready"); ready != "" {
+		want, err := strconv.ParseBool(ready)
+		if err != nil {
+			msg := fmt.Sprintf("invalid value %q for ready", ready)
+			c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: msg})
+			return
+		}
+		filtered := tasks[:0]
+		for _, t := range tasks {
+			if t.IsReady() == want {
+				filtered = append(filtered, t)
+			}
+		}
+		tasks = filtered
+	}
 	if len(tasks) == 0 {
 		c.JSON(http.StatusOK, []interface{}{})
 		return
@@ -352,42 +1029,65 @@ func (a *API) GetTasks(c *gin.Context) {
 }
 
 func (a *API) GetTasksbyID(c *gin.Context) {
-	tasks := a.Manager.GetTasks()
-	if len(tasks) == 0 {
-		c.JSON(http.StatusOK, []interface{}{})
-		return
-	}
 	tID := c.Param("taskID")
 	taskID, err := uuid.Parse(tID)
 	if err != nil {
 		fmt.Println("Error parsing UUID:", err)
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: err.Error()})
 		return
 	}
-	var t task.Task
-	for _, tk := range tasks {
-		if tk.ID == taskID {
-			t = tk
-		}
+	t, ok := a.Manager.GetTask(taskID)
+	if !ok {
+		msg := fmt.Sprintf("task %v does not exist", taskID)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
 	}
 	c.JSON(http.StatusOK, t)
 }
 
+// a transition of the history with the names of the states, which is easier to read than their numbers
+type TransitionResponse struct {
+	From   string
+	To     string
+	Time   time.Time
+	Reason string
+}
+
+func (a *API) GetTaskHistory(c *gin.Context) {
+	taskID, err := uuid.Parse(c.Param("taskID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	t, ok := a.Manager.GetTask(taskID)
+	if !ok {
+		msg := fmt.Sprintf("task %v does not exist", taskID)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
+	}
+	history := make([]TransitionResponse, 0, len(t.History))
+	for _, tr := range t.History {
+		history = append(history, TransitionResponse{From: tr.From.String(), To: tr.To.String(), Time: tr.Time, Reason: tr.Reason})
+	}
+	c.JSON(http.StatusOK, history)
+}
+
 func (a *API) StopTask(c *gin.Context) {
 	tID := c.Param("taskID")
 	utID, _ := uuid.Parse(tID)
 
-	_, ok := a.Manager.TaskDB[utID]
+	taskToStop, ok := a.Manager.GetTask(utID)
 	if !ok {
 		log.Printf("task does not exists, uuid: %v", utID)
 		c.Status(http.StatusNotFound)
+		return
 	}
 	te := task.TaskEvent{
 		ID:        uuid.New(),
 		State:     task.Completed,
 		Timestamp: time.Now(),
 	}
-	taskToStop := a.Manager.TaskDB[utID]
-	taskCopy := *taskToStop
+	taskCopy := taskToStop
 	taskCopy.State = task.Completed
 	te.Task = taskCopy
 	a.Manager.AddTask(te)
@@ -396,12 +1096,93 @@ func (a *API) StopTask(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+func (a *API) GetNodes(c *gin.Context) {
+	c.JSON(http.StatusOK, a.Manager.GetNodes())
+}
+
+func (a *API) GetNodeByName(c *gin.Context) {
+	name := c.Param("name")
+	n, ok := a.Manager.GetNode(name)
+	if !ok {
+		msg := fmt.Sprintf("node %s does not exist", name)
+		log.Println(msg)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
+	}
+	c.JSON(http.StatusOK, n)
+}
+
+func (a *API) RegisterWorker(c *gin.Context) {
+	d := json.NewDecoder(c.Request.Body)
+	d.DisallowUnknownFields()
+
+	r := worker.Registration{}
+	if err := d.Decode(&r); err != nil {
+		msg := fmt.Sprintf("error in unmarshalling body: %v", err)
+		log.Println(msg)
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: msg})
+		return
+	}
+	if r.Name == "" || r.Address == "" {
+		msg := "name and address of the worker are required"
+		log.Println(msg)
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: msg})
+		return
+	}
+
+	a.Manager.RegisterWorker(r)
+	c.Status(http.StatusCreated)
+}
+
+func (a *API) Heartbeat(c *gin.Context) {
+	if err := a.Manager.Heartbeat(c.Param("name")); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// only the leader has the state of the cluster, the followers only load it once they are elected
+// so they send the client to the leader instead, for reads as well as for writes
+func (a *API) leaderOnly(c *gin.Context) {
+	if a.Manager.Elector.IsLeader() {
+		c.Next()
+		return
+	}
+
+	leader := a.Manager.Elector.Leader()
+	if leader == "" {
+		msg := "there is no leader at the moment, try again later"
+		log.Println(msg)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrResponse{HTTPStatusCode: http.StatusServiceUnavailable, Message: msg})
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("http://%s%s", leader, c.Request.URL.RequestURI()))
+	c.Abort()
+}
+
 func (a *API) InitRouter() {
 	// tasks
-	a.Router.GET("/tasks", a.GetTasks)
-	a.Router.GET("/tasks/:taskID", a.GetTasksbyID)
-	a.Router.POST("/tasks", a.StartTask)
-	a.Router.DELETE("/tasks/:taskID", a.StopTask)
+	a.Router.GET("/tasks", a.leaderOnly, a.GetTasks)
+	a.Router.GET("/tasks/:taskID", a.leaderOnly, a.GetTasksbyID)
+	a.Router.GET("/tasks/:taskID/history", a.leaderOnly, a.GetTaskHistory)
+	a.Router.POST("/tasks", a.leaderOnly, a.StartTask)
+	a.Router.DELETE("/tasks/:taskID", a.leaderOnly, a.StopTask)
+
+	// nodes
+	a.Router.GET("/nodes", a.leaderOnly, a.GetNodes)
+	a.Router.GET("/nodes/:name", a.leaderOnly, a.GetNodeByName)
+
+	// workers
+	a.Router.POST("/workers", a.leaderOnly, a.RegisterWorker)
+	a.Router.PUT("/workers/:name/heartbeat", a.leaderOnly, a.Heartbeat)
+
+	// persistent volumes
+	a.Router.GET("/volumes", a.leaderOnly, a.GetVolumes)
+	a.Router.GET("/volumes/:name", a.leaderOnly, a.GetVolume)
+	a.Router.POST("/volumes", a.leaderOnly, a.CreateVolume)
+	a.Router.DELETE("/volumes/:name", a.leaderOnly, a.DeleteVolume)
 }
 
 func (a *API) Start() {
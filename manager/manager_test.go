@@ -0,0 +1,423 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/internal/stresstest"
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/task"
+	"github.com/hanshal101/core/worker"
+)
+
+func TestApplyStatsKeepsAllocations(t *testing.T) {
+	m := New(nil, scheduler.BinPackingType, nil)
+	m.RegisterWorker(worker.Registration{Name: "w1", Address: "127.0.0.1:1", Cores: 4, Memory: 8 << 30, Disk: 100 << 30})
+	n := m.getNode("w1")
+	// two tasks are placed on the worker but only one of them runs yet
+	allocate(n, task.Task{ID: uuid.New(), CPU: 1000, Memory: 1 << 30, Disk: 1 << 30})
+	allocate(n, task.Task{ID: uuid.New(), CPU: 1000, Memory: 1 << 30, Disk: 1 << 30})
+
+	m.applyStats("w1", &worker.Stats{
+		Cores:       8,
+		TaskCount:   1,
+		MemoryStats: &linux.MemInfo{MemTotal: 4 << 20, MemAvailable: 3 << 20},
+		DiskStats:   &linux.Disk{All: 50 << 30, Used: 10 << 30},
+	})
+
+	got, _ := m.GetNode("w1")
+	if got.TaskCount != 2 || got.CPUAllocated != 2000 || got.MemoryAllocated != 2<<30 || got.DiskAllocated != 2<<30 {
+		t.Errorf("allocations changed by the stats: %+v", got)
+	}
+	if got.Cores != 4 || got.Memory != 8<<30 || got.Disk != 100<<30 {
+		t.Errorf("registered capacity changed by the stats: cores %d memory %d disk %d", got.Cores, got.Memory, got.Disk)
+	}
+	if got.RunningTasks != 1 || got.MemoryCapacity != 4<<30 || got.MemoryUsed != 1<<30 || got.DiskCapacity != 50<<30 {
+		t.Errorf("reported stats not recorded: %+v", got)
+	}
+}
+
+func TestApplyStatsFillsUnknownCapacity(t *testing.T) {
+	m := New([]string{"127.0.0.1:1"}, scheduler.BinPackingType, nil)
+	m.applyStats("127.0.0.1:1", &worker.Stats{
+		Cores:       2,
+		MemoryStats: &linux.MemInfo{MemTotal: 1 << 20},
+		DiskStats:   &linux.Disk{All: 10 << 30},
+	})
+	got, _ := m.GetNode("127.0.0.1:1")
+	if got.Cores != 2 || got.Memory != 1<<30 || got.Disk != 10<<30 {
+		t.Errorf("capacity of a worker listed upfront not taken from its stats: %+v", got)
+	}
+}
+
+// a replica which lost the election to leader
+type follower struct {
+	leader string
+}
+
+func (f *follower) Run(elected func()) {}
+func (f *follower) IsLeader() bool     { return false }
+func (f *follower) Leader() string     { return f.leader }
+
+func TestFollowerRedirects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New(nil, scheduler.RoundRobinType, nil)
+	f := &follower{leader: "10.0.0.10:50050"}
+	m.Elector = f
+	a := API{Manager: m, Router: gin.New()}
+	a.InitRouter()
+
+	// the follower never loaded the state, so reads go to the leader as well
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/tasks?ready=true"},
+		{http.MethodGet, "/tasks/" + uuid.NewString()},
+		{http.MethodGet, "/tasks/" + uuid.NewString() + "/history"},
+		{http.MethodGet, "/nodes"},
+		{http.MethodGet, "/nodes/w1"},
+		{http.MethodGet, "/volumes"},
+		{http.MethodGet, "/volumes/data"},
+		{http.MethodPost, "/tasks"},
+		{http.MethodDelete, "/tasks/" + uuid.NewString()},
+		{http.MethodPost, "/workers"},
+	} {
+		rec := httptest.NewRecorder()
+		a.Router.ServeHTTP(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != http.StatusTemporaryRedirect {
+			t.Errorf("%s %s answered %d, want %d", req.method, req.path, rec.Code, http.StatusTemporaryRedirect)
+			continue
+		}
+		if got, want := rec.Header().Get("Location"), "http://10.0.0.10:50050"+req.path; got != want {
+			t.Errorf("%s %s redirected to %s, want %s", req.method, req.path, got, want)
+		}
+	}
+
+	f.leader = ""
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a leader GET /nodes answered %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// a worker which only records the events it gets, the starts take a while so that a stop sent next to them would overtake them
+func recordingWorker(t *testing.T, got chan<- task.TaskEvent) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var te task.TaskEvent
+		if err := json.NewDecoder(r.Body).Decode(&te); err != nil {
+			t.Errorf("worker got a bad event: %v", err)
+		}
+		if te.State != task.Completed {
+			time.Sleep(20 * time.Millisecond)
+		}
+		got <- te
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(te.Task)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestProcessBatchKeepsTheOrderOfATask(t *testing.T) {
+	got := make(chan task.TaskEvent, 100)
+	s := recordingWorker(t, got)
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.RegisterWorker(worker.Registration{Name: "w1", Address: strings.TrimPrefix(s.URL, "http://"), Cores: 64, Memory: 64 << 30})
+
+	var ids []uuid.UUID
+	for i := 0; i < 10; i++ {
+		tk := task.Task{ID: uuid.New(), Name: fmt.Sprintf("t%d", i)}
+		ids = append(ids, tk.ID)
+		m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk})
+		m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Completed, Task: tk})
+	}
+	m.processBatch()
+	close(got)
+
+	started := map[uuid.UUID]bool{}
+	stopped := 0
+	for te := range got {
+		if te.State != task.Completed {
+			started[te.Task.ID] = true
+			continue
+		}
+		if !started[te.Task.ID] {
+			t.Errorf("task %s was stopped before it was started", te.Task.Name)
+		}
+		stopped++
+	}
+	if len(started) != len(ids) || stopped != len(ids) {
+		t.Errorf("worker got %d starts and %d stops, want %d of each", len(started), stopped, len(ids))
+	}
+}
+
+func TestProcessBatchRequeuesTheRestOfATask(t *testing.T) {
+	// there is no worker, so the start goes back on the queue and the stop has to stay behind it
+	m := New(nil, scheduler.RoundRobinType, nil)
+	tk := task.Task{ID: uuid.New(), Name: "t"}
+	start := task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk}
+	stop := task.TaskEvent{ID: uuid.New(), State: task.Completed, Task: tk}
+	m.AddTask(start)
+	m.AddTask(stop)
+	m.processBatch()
+
+	if m.Pending.Len() != 2 {
+		t.Fatalf("%d pending events, want 2", m.Pending.Len())
+	}
+	if te := m.Pending.Dequeue().(task.TaskEvent); te.ID != start.ID {
+		t.Errorf("first pending event is %v, want the start", te.State)
+	}
+	if te := m.Pending.Dequeue().(task.TaskEvent); te.ID != stop.ID {
+		t.Errorf("second pending event is %v, want the stop", te.State)
+	}
+}
+
+// a worker running on the fake runtime behind its api, registered with the manager
+func fakeWorker(t *testing.T, m *Manager, name string) (*worker.Worker, *task.Fake) {
+	rt := task.NewFake()
+	w := worker.New(name, "", nil, nil, rt)
+	a := worker.API{Worker: w, Router: gin.New()}
+	a.InitRouter()
+	s := httptest.NewServer(a.Router)
+	t.Cleanup(s.Close)
+	m.RegisterWorker(worker.Registration{Name: name, Address: strings.TrimPrefix(s.URL, "http://"), Cores: 4, Memory: 8 << 30})
+	go w.RunTasks()
+	return w, rt
+}
+
+// what the manager knows about the task once it asked its workers
+func reported(t *testing.T, m *Manager, id uuid.UUID, what string, cond func(task.Task) bool) task.Task {
+	t.Helper()
+	var got task.Task
+	waitFor(t, what, func() bool {
+		m.updateTasks()
+		got, _ = m.GetTask(id)
+		return cond(got)
+	})
+	return got
+}
+
+func TestTaskOnFakeWorker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		exit      func(rt *task.Fake, id string) error
+		state     task.State
+		exitCode  int
+		oomKilled bool
+	}{
+		{"finished", func(rt *task.Fake, id string) error { return rt.Exit(id, 0) }, task.Completed, 0, false},
+		{"crashed", func(rt *task.Fake, id string) error { return rt.Exit(id, 3) }, task.Failed, 3, false},
+		{"out of memory", func(rt *task.Fake, id string) error { return rt.OOMKill(id) }, task.Failed, 137, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, scheduler.RoundRobinType, nil)
+			w, rt := fakeWorker(t, m, "w1")
+			tk := task.Task{ID: uuid.New(), Name: "job", Image: "busybox", CPU: 500, Memory: 1 << 30, RestartPolicy: task.RestartNever}
+			m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk})
+			m.processBatch()
+
+			got := reported(t, m, tk.ID, "the task to run", func(t task.Task) bool { return t.State == task.Running })
+			if got.ContainerID == "" {
+				t.Fatal("manager doesn't know the container of the task")
+			}
+			if n, _ := m.GetNode("w1"); n.TaskCount != 1 || n.CPUAllocated != 500 {
+				t.Errorf("node has %d tasks and %d cpu allocated, want 1 and 500", n.TaskCount, n.CPUAllocated)
+			}
+
+			if err := tt.exit(rt, got.ContainerID); err != nil {
+				t.Fatal(err)
+			}
+			// a single pass of the loop of the worker which notices exited containers
+			go w.UpdateTasks()
+			got = reported(t, m, tk.ID, "the exit to be reported", func(t task.Task) bool { return t.State != task.Running })
+			if got.State != tt.state || got.ExitCode != tt.exitCode || got.OOMKilled != tt.oomKilled {
+				t.Errorf("task is %v with exit code %d, oom killed %v, want %v, %d, %v", got.State, got.ExitCode, got.OOMKilled, tt.state, tt.exitCode, tt.oomKilled)
+			}
+			// the task is done, so it doesn't hold the worker anymore
+			if n, _ := m.GetNode("w1"); n.TaskCount != 0 || n.CPUAllocated != 0 {
+				t.Errorf("node still has %d tasks and %d cpu allocated", n.TaskCount, n.CPUAllocated)
+			}
+		})
+	}
+}
+
+// hits the api from many clients while the loops of the manager and the workers run, it is meant to be run with -race
+func TestConcurrentAPIAndLoops(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New(nil, scheduler.LeastLoadedType, nil)
+	for _, name := range []string{"w1", "w2"} {
+		w, _ := fakeWorker(t, m, name)
+		go w.CheckHealth()
+		go w.UpdateTasks()
+		go w.CollectStats()
+	}
+	m.MaxConcurrency = 4
+	go m.ProcessTasks()
+	go m.UpdateTasks()
+	go m.UpdateNodes()
+	go m.DoHealthChecks()
+	go m.DoHeartbeatChecks()
+
+	a := API{Manager: m, Router: gin.New()}
+	a.InitRouter()
+	s := httptest.NewServer(a.Router)
+	defer s.Close()
+
+	do := func(method, path string, body []byte, want ...int) {
+		stresstest.Do(t, method, s.URL+path, body, want...)
+	}
+	// the loops only come around every few seconds, so their passes are run back to back as well
+	stresstest.Run(8, 10, func(c, i int) {
+		tk := task.Task{ID: uuid.New(), Name: fmt.Sprintf("t%d-%d", c, i), Image: "busybox", CPU: 10, Memory: 1 << 20}
+		if i%2 == 0 {
+			tk.HealthCheck = &task.HealthCheck{Type: task.HealthExec, Command: []string{"true"}, IntervalSeconds: 1}
+		}
+		data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk})
+		do(http.MethodPost, "/tasks", data, http.StatusCreated)
+		do(http.MethodGet, "/tasks", nil, http.StatusOK)
+		do(http.MethodGet, "/tasks?ready=true", nil, http.StatusOK)
+		// the task shows up once the event was processed
+		do(http.MethodGet, "/tasks/"+tk.ID.String(), nil, http.StatusOK, http.StatusNotFound)
+		do(http.MethodGet, "/tasks/"+tk.ID.String()+"/history", nil, http.StatusOK, http.StatusNotFound)
+		do(http.MethodGet, "/nodes", nil, http.StatusOK)
+		do(http.MethodGet, "/nodes/w1", nil, http.StatusOK)
+		if i%3 == 0 {
+			do(http.MethodDelete, "/tasks/"+tk.ID.String(), nil, http.StatusNoContent, http.StatusNotFound)
+		}
+	}, m.processBatch, m.updateTasks, m.updateNodes, m.doHelathChecks)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for id, w := range m.TaskWorkerMap {
+		if w != "w1" && w != "w2" {
+			t.Errorf("task %v is assigned to unknown worker %q", id, w)
+		}
+	}
+}
+
+func TestStopIsRetried(t *testing.T) {
+	var mu sync.Mutex
+	stops := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		stops++
+		// the first stop gets lost on its way
+		if stops == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(worker.ErrResponse{HTTPStatusCode: http.StatusInternalServerError, Message: "blip"})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.RegisterWorker(worker.Registration{Name: "w1", Address: strings.TrimPrefix(s.URL, "http://"), Cores: 4, Memory: 8 << 30})
+	tk := task.Task{ID: uuid.New(), Name: "web", State: task.Running}
+	place(m, "w1", tk)
+	m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Completed, Task: tk})
+
+	m.processBatch()
+	if m.Pending.Len() != 1 {
+		t.Fatalf("%d pending events after the failed stop, want the stop to be kept", m.Pending.Len())
+	}
+	if pending, _ := m.Store.List(pendingBucket); len(pending) != 1 {
+		t.Errorf("%d pending events in the store after the failed stop, want 1", len(pending))
+	}
+	if got, _ := m.GetTask(tk.ID); got.State != task.Stopping {
+		t.Errorf("task is %v, want stopping", got.State)
+	}
+
+	m.processBatch()
+	if m.Pending.Len() != 0 {
+		t.Errorf("%d pending events after the stop went through", m.Pending.Len())
+	}
+	if stops != 2 {
+		t.Errorf("worker got %d stops, want 2", stops)
+	}
+	if pending, _ := m.Store.List(pendingBucket); len(pending) != 0 {
+		t.Errorf("%d pending events left in the store", len(pending))
+	}
+}
+
+// the worker was last heard of the given time ago
+func silent(m *Manager, name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getNode(name).LastHeartbeat = time.Now().UTC().Add(-d)
+}
+
+func TestMissedHeartbeatsRescheduleTasks(t *testing.T) {
+	got := make(chan task.TaskEvent, 10)
+	s := recordingWorker(t, got)
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.HeartbeatTimeout = time.Minute
+	m.WorkerGracePeriod = 5 * time.Minute
+	m.WorkerRemoveTimeout = time.Hour
+	for _, name := range []string{"w1", "w2"} {
+		m.RegisterWorker(worker.Registration{Name: name, Address: strings.TrimPrefix(s.URL, "http://"), Cores: 4, Memory: 8 << 30})
+	}
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Running, ContainerID: "abc"}
+	place(m, "w1", tk)
+	done := task.Task{ID: uuid.New(), Name: "job", Image: "busybox", State: task.Completed}
+	place(m, "w1", done)
+
+	// missed its heartbeats, but it may only be slow
+	silent(m, "w1", 2*time.Minute)
+	m.checkHeartbeats()
+	if n := m.getNode("w1"); n.Healthy {
+		t.Fatal("worker which missed its heartbeats is healthy")
+	}
+	if got, _ := m.GetTask(tk.ID); got.State != task.Unknown || m.TaskWorkerMap[tk.ID] != "w1" {
+		t.Fatalf("task is %v on %q before the grace period is over", got.State, m.TaskWorkerMap[tk.ID])
+	}
+	if m.Pending.Len() != 0 {
+		t.Fatalf("%d pending events before the grace period is over", m.Pending.Len())
+	}
+
+	// gone for good, its tasks go to another worker
+	silent(m, "w1", 10*time.Minute)
+	m.checkHeartbeats()
+	if got, _ := m.GetTask(tk.ID); got.State != task.Lost {
+		t.Fatalf("task is %v, want lost", got.State)
+	}
+	if got, _ := m.GetTask(done.ID); got.State != task.Completed {
+		t.Errorf("finished task is %v, want it left alone", got.State)
+	}
+	if _, ok := m.TaskWorkerMap[tk.ID]; ok {
+		t.Error("lost task is still assigned to its worker")
+	}
+	if n := m.getNode("w1"); n.CPUAllocated != 0 || n.MemoryAllocated != 0 {
+		t.Errorf("unreachable worker still has %d cpu and %d memory allocated", n.CPUAllocated, n.MemoryAllocated)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("%d pending events, want the one placing the task again", m.Pending.Len())
+	}
+
+	m.processBatch()
+	if w := m.TaskWorkerMap[tk.ID]; w != "w2" {
+		t.Errorf("lost task was placed on %q, want w2", w)
+	}
+	if te := <-got; te.Task.ID != tk.ID || te.Task.ContainerID != "" {
+		t.Errorf("worker got task %v with container %q, want the lost task without its container", te.Task.ID, te.Task.ContainerID)
+	}
+
+	// it is removed from the cluster after a while
+	silent(m, "w1", 2*time.Hour)
+	m.checkHeartbeats()
+	if m.getNode("w1") != nil {
+		t.Error("worker gone for longer than the remove timeout is still in the cluster")
+	}
+}
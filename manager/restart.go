@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/task"
+)
+
+// the delay before the first restart of a task, every restart after it doubles the delay up to the max
+const (
+	DefaultRestartBackoff    = 10 * time.Second
+	DefaultMaxRestartBackoff = 5 * time.Minute
+)
+
+// how long to wait before restarting a task which was restarted that many times already
+// the jitter keeps tasks which failed together from being restarted together
+func (m *Manager) backoff(restarts int) time.Duration {
+	d := m.RestartBackoff
+	for i := 0; i < restarts && d < m.MaxRestartBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, m.MaxRestartBackoff)
+	// somewhere between half and all of the delay
+	return d/2 + time.Duration(m.jitter(int64(d/2)+1))
+}
+
+// a task which was stopped on request stays stopped, whatever its restart policy says
+func stopRequested(t *task.Task) bool {
+	for i := len(t.History) - 1; i >= 0; i-- {
+		switch t.History[i].To {
+		case task.Stopping:
+			return true
+		case task.Scheduled, task.Restarting:
+			return false
+		}
+	}
+	return false
+}
+
+// when the task is due for a restart, the first time this is asked the backoff is counted from since
+func (m *Manager) restartDue(t *task.Task, since time.Time) time.Time {
+	due, ok := m.restartAt[t.ID]
+	if !ok {
+		due = since.Add(m.backoff(t.RestartCount))
+		m.restartAt[t.ID] = due
+		log.Printf("Task %v will be restarted at %v\n", t.ID, due)
+	}
+	return due
+}
+
+// starts the tasks which completed or failed again, if their restart policy asks for it
+// they go through the scheduler again, so they can end up on another worker
+func (m *Manager) restartTasks() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	for id, t := range m.TaskDB {
+		if t.State != task.Completed && t.State != task.Failed {
+			continue
+		}
+		w, ok := m.TaskWorkerMap[id]
+		if !ok || stopRequested(t) || !t.ShouldRestart() {
+			delete(m.restartAt, id)
+			continue
+		}
+		since := t.EndTime
+		if since.IsZero() {
+			since = now
+		}
+		if now.Before(m.restartDue(t, since)) {
+			continue
+		}
+
+		reason := fmt.Sprintf("restart policy %s, the task completed", t.RestartPolicy)
+		if t.State == task.Failed {
+			reason = fmt.Sprintf("restart policy %s, the task failed", t.RestartPolicy)
+			if t.RestartPolicy == "" {
+				reason = "the task failed"
+			}
+		}
+		m.reschedule(w, id, reason)
+	}
+}
+
+// takes the finished task off its worker and puts it back on the pending queue
+// it doesn't hold any resources of the worker anymore, so there is nothing to release
+func (m *Manager) reschedule(w string, id uuid.UUID, reason string) {
+	t := m.TaskDB[id]
+	if !t.TryTransition(task.Restarting, reason) {
+		return
+	}
+	t.RestartCount++
+	m.saveTask(t)
+	delete(m.restartAt, id)
+
+	ids := m.WorkerTaskMap[w]
+	for i, tid := range ids {
+		if tid == id {
+			m.WorkerTaskMap[w] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	delete(m.TaskWorkerMap, id)
+	m.deleteAssignment(id)
+
+	log.Printf("Restarting task %v (restart %d): %s\n", id, t.RestartCount, reason)
+	taskCopy := *t
+	taskCopy.ContainerID = ""
+	taskCopy.HostPort = nil
+	m.enqueue(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now().UTC(),
+		Task:      taskCopy,
+	})
+}
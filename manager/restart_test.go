@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/task"
+	"github.com/hanshal101/core/worker"
+)
+
+func TestBackoff(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.RestartBackoff = 10 * time.Second
+	m.MaxRestartBackoff = time.Minute
+
+	tests := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		// capped from here on
+		{3, time.Minute},
+		{10, time.Minute},
+		{1000, time.Minute},
+	}
+	for _, tt := range tests {
+		// the jitter takes off at most half of the delay
+		m.jitter = func(n int64) int64 { return 0 }
+		if got := m.backoff(tt.restarts); got != tt.want/2 {
+			t.Errorf("backoff(%d) without jitter = %v, want %v", tt.restarts, got, tt.want/2)
+		}
+		m.jitter = func(n int64) int64 { return n - 1 }
+		if got := m.backoff(tt.restarts); got != tt.want {
+			t.Errorf("backoff(%d) with the most jitter = %v, want %v", tt.restarts, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffJitterIsBounded(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.RestartBackoff = time.Second
+	m.MaxRestartBackoff = 8 * time.Second
+	for i := 0; i < 1000; i++ {
+		restarts := i % 6
+		want := min(time.Second<<restarts, 8*time.Second)
+		if got := m.backoff(restarts); got < want/2 || got > want {
+			t.Fatalf("backoff(%d) = %v, want between %v and %v", restarts, got, want/2, want)
+		}
+	}
+}
+
+func TestRestartDue(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.jitter = func(n int64) int64 { return n - 1 }
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tk := &task.Task{ID: uuid.New(), RestartCount: 2}
+
+	due := m.restartDue(tk, since)
+	if want := since.Add(4 * DefaultRestartBackoff); !due.Equal(want) {
+		t.Errorf("restart due at %v, want %v", due, want)
+	}
+	// once it is due it stays due at the same time, whatever is asked later
+	tk.RestartCount = 5
+	if again := m.restartDue(tk, since.Add(time.Hour)); !again.Equal(due) {
+		t.Errorf("restart moved from %v to %v", due, again)
+	}
+}
+
+func TestStopRequested(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []task.State
+		want    bool
+	}{
+		{"never stopped", []task.State{task.Scheduled, task.Running, task.Failed}, false},
+		{"stopped", []task.State{task.Scheduled, task.Running, task.Stopping, task.Completed}, true},
+		{"scheduled again after the stop", []task.State{task.Running, task.Stopping, task.Completed, task.Restarting, task.Scheduled, task.Running, task.Failed}, false},
+		{"no history", nil, false},
+	}
+	for _, tt := range tests {
+		tk := &task.Task{}
+		for _, s := range tt.history {
+			tk.History = append(tk.History, task.Transition{To: s})
+		}
+		if got := stopRequested(tk); got != tt.want {
+			t.Errorf("%s: stopRequested = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRestartTasks(t *testing.T) {
+	ended := time.Now().UTC().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		task     task.Task
+		restarts bool
+	}{
+		{"failed without a policy", task.Task{State: task.Failed}, true},
+		{"completed without a policy", task.Task{State: task.Completed}, false},
+		{"failed with on failure", task.Task{State: task.Failed, RestartPolicy: task.RestartOnFailure}, true},
+		{"completed with always", task.Task{State: task.Completed, RestartPolicy: task.RestartAlways}, true},
+		{"failed with never", task.Task{State: task.Failed, RestartPolicy: task.RestartNever}, false},
+		{"out of retries", task.Task{State: task.Failed, MaxRetries: 3, RestartCount: 3}, false},
+		{"retries left", task.Task{State: task.Failed, MaxRetries: 3, RestartCount: 2}, true},
+		{"stopped on request", task.Task{State: task.Completed, RestartPolicy: task.RestartAlways, History: []task.Transition{{To: task.Stopping}, {To: task.Completed}}}, false},
+		// a backoff of two hours isn't over an hour after the task ended
+		{"backoff not over", task.Task{State: task.Failed, RestartCount: 20}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, scheduler.RoundRobinType, nil)
+			m.RestartBackoff = time.Minute
+			m.MaxRestartBackoff = 2 * time.Hour
+			m.jitter = func(n int64) int64 { return n - 1 }
+			m.RegisterWorker(worker.Registration{Name: "w1", Address: "127.0.0.1:1", Cores: 4, Memory: 8 << 30})
+			tk := tt.task
+			tk.ID = uuid.New()
+			tk.EndTime = ended
+			place(m, "w1", tk)
+
+			m.restartTasks()
+			got, _ := m.GetTask(tk.ID)
+			if restarted := got.State == task.Restarting; restarted != tt.restarts {
+				t.Fatalf("task is %v, restarted %v, want %v", got.State, restarted, tt.restarts)
+			}
+			if !tt.restarts {
+				if _, ok := m.TaskWorkerMap[tk.ID]; !ok {
+					t.Error("task which isn't restarted left its worker")
+				}
+				return
+			}
+			if got.RestartCount != tt.task.RestartCount+1 {
+				t.Errorf("restart count is %d, want %d", got.RestartCount, tt.task.RestartCount+1)
+			}
+			if _, ok := m.TaskWorkerMap[tk.ID]; ok {
+				t.Error("restarted task is still assigned to its old worker")
+			}
+			if m.Pending.Len() != 1 {
+				t.Errorf("%d pending events, want the one placing the task again", m.Pending.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/task"
+	"github.com/hanshal101/core/worker"
+)
+
+// buckets in which the manager keeps its state
+// the in-memory maps are only a cache of what is in the store, every change is written through
+const (
+	tasksBucket       = "tasks"
+	eventsBucket      = "events"
+	assignmentsBucket = "assignments"
+	pendingBucket     = "pending"
+	workersBucket     = "workers"
+)
+
+// the error is logged already, it is only returned for the callers which must not go on without the write
+func (m *Manager) saveTask(t *task.Task) error {
+	err := m.Store.Put(tasksBucket, t.ID.String(), t)
+	if err != nil {
+		log.Printf("Error in saving task %v: %v\n", t.ID, err)
+	}
+	return err
+}
+
+func (m *Manager) saveEvent(te *task.TaskEvent) {
+	if err := m.Store.Put(eventsBucket, te.ID.String(), te); err != nil {
+		log.Printf("Error in saving task event %v: %v\n", te.ID, err)
+	}
+}
+
+func (m *Manager) saveAssignment(taskID uuid.UUID, worker string) error {
+	err := m.Store.Put(assignmentsBucket, taskID.String(), worker)
+	if err != nil {
+		log.Printf("Error in saving assignment of task %v: %v\n", taskID, err)
+	}
+	return err
+}
+
+func (m *Manager) deleteAssignment(taskID uuid.UUID) {
+	if err := m.Store.Delete(assignmentsBucket, taskID.String()); err != nil {
+		log.Printf("Error in deleting assignment of task %v: %v\n", taskID, err)
+	}
+}
+
+func (m *Manager) saveWorker(r worker.Registration) {
+	if err := m.Store.Put(workersBucket, r.Name, r); err != nil {
+		log.Printf("Error in saving worker %s: %v\n", r.Name, err)
+	}
+}
+
+func (m *Manager) deleteWorker(name string) {
+	if err := m.Store.Delete(workersBucket, name); err != nil {
+		log.Printf("Error in deleting worker %s: %v\n", name, err)
+	}
+}
+
+// puts the event on the pending queue, the event stays in the store until it is done
+func (m *Manager) enqueue(te task.TaskEvent) {
+	if err := m.Store.Put(pendingBucket, te.ID.String(), te); err != nil {
+		log.Printf("Error in saving pending task event %v: %v\n", te.ID, err)
+	}
+	m.Pending.Enqueue(te)
+	m.signal()
+}
+
+// the event has been handled and doesn't have to be replayed after a restart
+func (m *Manager) done(te task.TaskEvent) {
+	if err := m.Store.Delete(pendingBucket, te.ID.String()); err != nil {
+		log.Printf("Error in deleting pending task event %v: %v\n", te.ID, err)
+	}
+}
+
+// starts taking part in the leader election, every time this replica becomes the leader
+// it throws away its state and loads the one written by the previous leader
+func (m *Manager) Lead() {
+	m.Elector.Run(m.reload)
+}
+
+func (m *Manager) reload() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Pending = *queue.New()
+	m.TaskDB = make(map[uuid.UUID]*task.Task)
+	m.EventDB = make(map[uuid.UUID]*task.TaskEvent)
+	m.TaskWorkerMap = make(map[uuid.UUID]string)
+	m.WorkerTaskMap = make(map[string][]uuid.UUID)
+	m.Volumes = make(map[string]*PersistentVolume)
+	m.restartAt = make(map[uuid.UUID]time.Time)
+	for _, n := range m.WorkerNodes {
+		m.WorkerTaskMap[n.Name] = []uuid.UUID{}
+		n.CPUAllocated = 0
+		n.MemoryAllocated = 0
+		n.DiskAllocated = 0
+		n.TaskCount = 0
+		n.Ports = nil
+	}
+
+	if err := m.restore(); err != nil {
+		log.Printf("Error in restoring the manager state: %v\n", err)
+	}
+}
+
+// rebuilds the maps and the pending queue from the store, this has to be called once on boot before any of the loops start
+func (m *Manager) Restore() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restore()
+}
+
+func (m *Manager) restore() error {
+	tasks, err := m.Store.List(tasksBucket)
+	if err != nil {
+		return err
+	}
+	for _, data := range tasks {
+		var t task.Task
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.Printf("Error in decoding stored task: %v\n", err)
+			continue
+		}
+		m.TaskDB[t.ID] = &t
+	}
+
+	events, err := m.Store.List(eventsBucket)
+	if err != nil {
+		return err
+	}
+	for _, data := range events {
+		var te task.TaskEvent
+		if err := json.Unmarshal(data, &te); err != nil {
+			log.Printf("Error in decoding stored task event: %v\n", err)
+			continue
+		}
+		m.EventDB[te.ID] = &te
+	}
+
+	if err := m.restoreVolumes(); err != nil {
+		return err
+	}
+
+	workers, err := m.Store.List(workersBucket)
+	if err != nil {
+		return err
+	}
+	for _, data := range workers {
+		var r worker.Registration
+		if err := json.Unmarshal(data, &r); err != nil {
+			log.Printf("Error in decoding stored worker: %v\n", err)
+			continue
+		}
+		m.registerWorker(r)
+	}
+
+	assignments, err := m.Store.List(assignmentsBucket)
+	if err != nil {
+		return err
+	}
+	for key, data := range assignments {
+		id, err := uuid.Parse(key)
+		if err != nil {
+			log.Printf("Error in parsing stored assignment %s: %v\n", key, err)
+			continue
+		}
+		var w string
+		if err := json.Unmarshal(data, &w); err != nil {
+			log.Printf("Error in decoding stored assignment %s: %v\n", key, err)
+			continue
+		}
+		m.TaskWorkerMap[id] = w
+		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], id)
+
+		t, ok := m.TaskDB[id]
+		if n := m.getNode(w); n != nil && ok && !finished(t.State) {
+			allocate(n, *t)
+		}
+	}
+	pending, err := m.Store.List(pendingBucket)
+	if err != nil {
+		return err
+	}
+	var queued []task.TaskEvent
+	for _, data := range pending {
+		var te task.TaskEvent
+		if err := json.Unmarshal(data, &te); err != nil {
+			log.Printf("Error in decoding stored pending task event: %v\n", err)
+			continue
+		}
+		queued = append(queued, te)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].Timestamp.Before(queued[j].Timestamp)
+	})
+	for _, te := range queued {
+		// we went down while sending the task to a worker, we can't tell if it got there
+		// so it is placed again, and if it did get there the old copy gets stopped by updateTasks
+		if w, ok := m.TaskWorkerMap[te.Task.ID]; ok && te.State != task.Completed {
+			m.unassign(w, te.Task)
+		}
+		m.Pending.Enqueue(te)
+	}
+
+	// tasks of workers which are not part of the cluster anymore have to find a new home
+	for w := range m.WorkerTaskMap {
+		if m.getNode(w) == nil {
+			m.rescheduleTasks(w)
+			delete(m.WorkerTaskMap, w)
+		}
+	}
+
+	log.Printf("Restored %d tasks, %d workers and %d pending events\n", len(m.TaskDB), len(m.WorkerNodes), m.Pending.Len())
+	return nil
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/store"
+	"github.com/hanshal101/core/task"
+	"github.com/hanshal101/core/worker"
+)
+
+// puts the task on the worker like SendWork does before it talks to the worker
+func place(m *Manager, w string, t task.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TaskDB[t.ID] = &t
+	m.saveTask(&t)
+	m.TaskWorkerMap[t.ID] = w
+	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], t.ID)
+	m.saveAssignment(t.ID, w)
+	if !finished(t.State) {
+		allocate(m.getNode(w), t)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(nil, scheduler.BinPackingType, s)
+	m.RegisterWorker(worker.Registration{Name: "w1", Address: "127.0.0.1:1", Cores: 4, Memory: 8 << 30})
+
+	running := task.Task{ID: uuid.New(), Name: "running", State: task.Running, CPU: 500, Memory: 1 << 30}
+	done := task.Task{ID: uuid.New(), Name: "done", State: task.Completed, CPU: 1000}
+	place(m, "w1", running)
+	place(m, "w1", done)
+
+	// we went down while sending this one, so it is still pending although it has a worker
+	sending := task.Task{ID: uuid.New(), Name: "sending", State: task.Scheduled, CPU: 2000}
+	place(m, "w1", sending)
+	m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Timestamp: time.Now().UTC(), Task: sending})
+	waiting := task.Task{ID: uuid.New(), Name: "waiting"}
+	m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Timestamp: time.Now().UTC().Add(-time.Minute), Task: waiting})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	restored := New(nil, scheduler.BinPackingType, s)
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("error in restoring: %v", err)
+	}
+
+	for _, want := range []task.Task{running, done, sending} {
+		got, ok := restored.GetTask(want.ID)
+		if !ok || got.Name != want.Name || got.State != want.State {
+			t.Errorf("task %s restored as %+v", want.Name, got)
+		}
+	}
+	if w := restored.TaskWorkerMap[running.ID]; w != "w1" {
+		t.Errorf("running task is assigned to %q, want w1", w)
+	}
+	if _, ok := restored.TaskWorkerMap[sending.ID]; ok {
+		t.Error("task which was being sent is still assigned, it has to be placed again")
+	}
+
+	// only the running task still holds resources of the worker
+	n, ok := restored.GetNode("w1")
+	if !ok {
+		t.Fatal("worker w1 wasn't restored")
+	}
+	if n.TaskCount != 1 || n.CPUAllocated != 500 || n.MemoryAllocated != 1<<30 {
+		t.Errorf("allocations of w1 = %d tasks, %d cpu, %d memory, want 1, 500, %d", n.TaskCount, n.CPUAllocated, n.MemoryAllocated, 1<<30)
+	}
+
+	// the pending events come back oldest first
+	if restored.Pending.Len() != 2 {
+		t.Fatalf("%d pending events, want 2", restored.Pending.Len())
+	}
+	if te := restored.Pending.Dequeue().(task.TaskEvent); te.Task.ID != waiting.ID {
+		t.Errorf("first pending event is for %s, want waiting", te.Task.Name)
+	}
+	if te := restored.Pending.Dequeue().(task.TaskEvent); te.Task.ID != sending.ID {
+		t.Errorf("second pending event is for %s, want sending", te.Task.Name)
+	}
+}
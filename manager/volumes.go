@@ -0,0 +1,276 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+const volumesBucket = "volumes"
+
+// a volume whose data has to outlive the tasks using it
+// a task claims it by mounting a volume of the same name, the first task placed binds the volume to its worker
+// from then on every task claiming the volume is only placed on that worker, since that is where the data is
+// if the worker is gone the tasks stay pending until it comes back or the volume is deleted
+type PersistentVolume struct {
+	Name   string
+	Worker string
+	// the task whose placement bound the volume, as long as its worker hasn't taken it yet
+	BoundBy uuid.UUID
+	Created time.Time
+}
+
+func (m *Manager) saveVolume(v *PersistentVolume) {
+	if err := m.Store.Put(volumesBucket, v.Name, v); err != nil {
+		log.Printf("Error in saving volume %s: %v\n", v.Name, err)
+	}
+}
+
+func (m *Manager) restoreVolumes() error {
+	volumes, err := m.Store.List(volumesBucket)
+	if err != nil {
+		return err
+	}
+	for _, data := range volumes {
+		var v PersistentVolume
+		if err := json.Unmarshal(data, &v); err != nil {
+			log.Printf("Error in decoding stored volume: %v\n", err)
+			continue
+		}
+		m.Volumes[v.Name] = &v
+	}
+	return nil
+}
+
+func (m *Manager) GetVolumes() []PersistentVolume {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	volumes := make([]PersistentVolume, 0, len(m.Volumes))
+	for _, v := range m.Volumes {
+		volumes = append(volumes, *v)
+	}
+	return volumes
+}
+
+func (m *Manager) GetVolume(name string) (PersistentVolume, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.Volumes[name]
+	if !ok {
+		return PersistentVolume{}, false
+	}
+	return *v, true
+}
+
+func (m *Manager) AddVolume(name string) (PersistentVolume, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Volumes[name]; ok {
+		return PersistentVolume{}, fmt.Errorf("volume %s already exists", name)
+	}
+	v := &PersistentVolume{Name: name, Created: time.Now().UTC()}
+	m.Volumes[name] = v
+	m.saveVolume(v)
+	return *v, nil
+}
+
+// the persistent volumes the task claims
+func (m *Manager) claims(t task.Task) []*PersistentVolume {
+	var claims []*PersistentVolume
+	for _, mt := range t.Volumes() {
+		if v, ok := m.Volumes[mt.Source]; ok {
+			claims = append(claims, v)
+		}
+	}
+	return claims
+}
+
+// narrows the nodes down to the worker holding the volumes of the task, if any of them is bound already
+func (m *Manager) volumeNodes(t task.Task, nodes []*node.Node) ([]*node.Node, error) {
+	worker := ""
+	for _, v := range m.claims(t) {
+		if v.Worker == "" {
+			continue
+		}
+		if worker != "" && worker != v.Worker {
+			return nil, fmt.Errorf("the volumes of task %v live on different workers, %s and %s", t.ID, worker, v.Worker)
+		}
+		worker = v.Worker
+	}
+	if worker == "" {
+		return nodes, nil
+	}
+	for _, n := range nodes {
+		if n.Name == worker {
+			return []*node.Node{n}, nil
+		}
+	}
+	return nil, fmt.Errorf("the volumes of task %v live on worker %s which is not available", t.ID, worker)
+}
+
+// binds the volumes the task claims to the worker it was placed on
+// the worker must never remove them when the task stops, so their mounts are marked to be kept
+func (m *Manager) claimVolumes(t task.Task, worker string) []task.Mount {
+	mounts := append([]task.Mount(nil), t.Mounts...)
+	for i, mt := range mounts {
+		v, ok := m.Volumes[mt.Source]
+		if mt.Type != task.MountVolume || !ok {
+			continue
+		}
+		mounts[i].Keep = true
+		if v.Worker == "" {
+			log.Printf("Binding volume %s to worker %s\n", v.Name, worker)
+			v.Worker = worker
+			v.BoundBy = t.ID
+			m.saveVolume(v)
+		}
+	}
+	return mounts
+}
+
+// the worker took the task, so the volumes bound by placing it stay bound
+func (m *Manager) keepVolumes(id uuid.UUID) {
+	for _, v := range m.Volumes {
+		if v.BoundBy == id {
+			v.BoundBy = uuid.Nil
+			m.saveVolume(v)
+		}
+	}
+}
+
+// the task never made it to its worker, so the volumes it bound hold no data there and are free again
+func (m *Manager) unbindVolumes(id uuid.UUID) {
+	for _, v := range m.Volumes {
+		if v.BoundBy == id {
+			log.Printf("Unbinding volume %s from worker %s\n", v.Name, v.Worker)
+			v.Worker = ""
+			v.BoundBy = uuid.Nil
+			m.saveVolume(v)
+		}
+	}
+}
+
+// deletes the volume, along with its data on the worker it is bound to
+// a volume can't be deleted while a task which isn't finished claims it
+func (m *Manager) DeleteVolume(name string) error {
+	m.mu.Lock()
+	v, ok := m.Volumes[name]
+	if !ok {
+		m.mu.Unlock()
+		return errVolumeNotFound
+	}
+	for _, t := range m.TaskDB {
+		if finished(t.State) {
+			continue
+		}
+		for _, mt := range t.Volumes() {
+			if mt.Source == name {
+				m.mu.Unlock()
+				return fmt.Errorf("volume %s is claimed by task %v", name, t.ID)
+			}
+		}
+	}
+	api := ""
+	if n := m.getNode(v.Worker); n != nil {
+		api = n.Api
+	}
+	m.mu.Unlock()
+
+	if api != "" {
+		if err := removeVolume(api, name); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Volumes, name)
+	if err := m.Store.Delete(volumesBucket, name); err != nil {
+		log.Printf("Error in deleting volume %s: %v\n", name, err)
+	}
+	return nil
+}
+
+var errVolumeNotFound = errors.New("volume does not exist")
+
+// asks the worker at api to remove the data of the volume
+func removeVolume(api string, name string) error {
+	url := fmt.Sprintf("%s/volumes/%s", api, name)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in connecting to worker at %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("worker could not remove volume %s (%d)", name, resp.StatusCode)
+	}
+	return nil
+}
+
+func (a *API) GetVolumes(c *gin.Context) {
+	c.JSON(http.StatusOK, a.Manager.GetVolumes())
+}
+
+func (a *API) GetVolume(c *gin.Context) {
+	name := c.Param("name")
+	v, ok := a.Manager.GetVolume(name)
+	if !ok {
+		msg := fmt.Sprintf("volume %s does not exist", name)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (a *API) CreateVolume(c *gin.Context) {
+	d := json.NewDecoder(c.Request.Body)
+	d.DisallowUnknownFields()
+
+	r := struct{ Name string }{}
+	if err := d.Decode(&r); err != nil {
+		msg := fmt.Sprintf("error in unmarshalling body: %v", err)
+		log.Println(msg)
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: msg})
+		return
+	}
+	// the name has to be one a task can mount
+	if err := (task.Mount{Type: task.MountVolume, Source: r.Name, Target: "/"}).Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	v, err := a.Manager.AddVolume(r.Name)
+	if err != nil {
+		c.JSON(http.StatusConflict, ErrResponse{HTTPStatusCode: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, v)
+}
+
+func (a *API) DeleteVolume(c *gin.Context) {
+	name := c.Param("name")
+	err := a.Manager.DeleteVolume(name)
+	switch {
+	case errors.Is(err, errVolumeNotFound):
+		msg := fmt.Sprintf("volume %s does not exist", name)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+	case err != nil:
+		log.Println(err)
+		c.JSON(http.StatusConflict, ErrResponse{HTTPStatusCode: http.StatusConflict, Message: err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/scheduler"
+	"github.com/hanshal101/core/task"
+	"github.com/hanshal101/core/worker"
+)
+
+func claiming(volumes ...string) task.Task {
+	t := task.Task{ID: uuid.New(), Name: "db", Image: "postgres"}
+	for _, v := range volumes {
+		t.Mounts = append(t.Mounts, task.Mount{Type: task.MountVolume, Source: v, Target: "/" + v})
+	}
+	return t
+}
+
+func TestVolumeNodes(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	m.AddVolume("data")
+	m.AddVolume("logs")
+	m.AddVolume("free")
+	m.Volumes["data"].Worker = "w2"
+	m.Volumes["logs"].Worker = "w1"
+	nodes := []*node.Node{node.NewNode("w1", "", "worker"), node.NewNode("w2", "", "worker")}
+
+	tests := []struct {
+		name    string
+		task    task.Task
+		want    []string
+		wantErr bool
+	}{
+		{"no volumes", claiming(), []string{"w1", "w2"}, false},
+		{"unbound volume", claiming("free"), []string{"w1", "w2"}, false},
+		{"volume which isn't persistent", claiming("scratch"), []string{"w1", "w2"}, false},
+		{"bound volume", claiming("data"), []string{"w2"}, false},
+		{"bound and unbound", claiming("free", "data"), []string{"w2"}, false},
+		{"volumes on different workers", claiming("data", "logs"), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := m.volumeNodes(tt.task, nodes)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		var names []string
+		for _, n := range got {
+			names = append(names, n.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: nodes %v, want %v", tt.name, names, tt.want)
+		}
+	}
+
+	// the worker holding the data is gone
+	if _, err := m.volumeNodes(claiming("data"), nodes[:1]); err == nil {
+		t.Error("task placed although the worker of its volume is not available")
+	}
+}
+
+// a worker which answers every request with status and records the paths of the deletes
+type volumeWorker struct {
+	mu      sync.Mutex
+	status  int
+	deletes []string
+}
+
+func (v *volumeWorker) start(t *testing.T, m *Manager, name string) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if r.Method == http.MethodDelete {
+			v.deletes = append(v.deletes, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(v.status)
+	}))
+	t.Cleanup(s.Close)
+	m.RegisterWorker(worker.Registration{Name: name, Address: strings.TrimPrefix(s.URL, "http://"), Cores: 4, Memory: 8 << 30})
+}
+
+func TestVolumeStickyPlacement(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	(&volumeWorker{status: http.StatusCreated}).start(t, m, "w1")
+	(&volumeWorker{status: http.StatusCreated}).start(t, m, "w2")
+	m.AddVolume("data")
+
+	var bound string
+	for i := 0; i < 4; i++ {
+		tk := claiming("data")
+		m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk})
+		m.processBatch()
+
+		v, _ := m.GetVolume("data")
+		if i == 0 {
+			bound = v.Worker
+		}
+		if v.Worker == "" || v.Worker != bound || v.BoundBy != uuid.Nil {
+			t.Fatalf("volume is bound to %q by %v after task %d, want %q", v.Worker, v.BoundBy, i, bound)
+		}
+		if w := m.TaskWorkerMap[tk.ID]; w != bound {
+			t.Errorf("task %d was placed on %s, want %s where its volume lives", i, w, bound)
+		}
+		got, _ := m.GetTask(tk.ID)
+		if len(got.Mounts) != 1 || !got.Mounts[0].Keep {
+			t.Errorf("mount of the persistent volume isn't kept: %+v", got.Mounts)
+		}
+	}
+}
+
+func TestFailedPlacementDoesNotBind(t *testing.T) {
+	tests := []struct {
+		name   string
+		worker func(t *testing.T, m *Manager)
+	}{
+		{"worker unreachable", func(t *testing.T, m *Manager) {
+			s := httptest.NewServer(http.NotFoundHandler())
+			s.Close()
+			m.RegisterWorker(worker.Registration{Name: "w1", Address: strings.TrimPrefix(s.URL, "http://"), Cores: 4, Memory: 8 << 30})
+		}},
+		{"worker refused the task", func(t *testing.T, m *Manager) {
+			(&volumeWorker{status: http.StatusBadRequest}).start(t, m, "w1")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, scheduler.RoundRobinType, nil)
+			tt.worker(t, m)
+			m.AddVolume("data")
+			m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: claiming("data")})
+			m.processBatch()
+
+			if v, _ := m.GetVolume("data"); v.Worker != "" || v.BoundBy != uuid.Nil {
+				t.Errorf("volume is bound to %q by %v although the worker never took the task", v.Worker, v.BoundBy)
+			}
+			var stored PersistentVolume
+			if err := m.Store.Get(volumesBucket, "data", &stored); err != nil || stored.Worker != "" {
+				t.Errorf("stored volume is bound to %q, %v", stored.Worker, err)
+			}
+		})
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	m := New(nil, scheduler.RoundRobinType, nil)
+	w := &volumeWorker{status: http.StatusCreated}
+	w.start(t, m, "w1")
+	m.AddVolume("data")
+	m.Volumes["data"].Worker = "w1"
+	tk := claiming("data")
+	tk.State = task.Running
+	place(m, "w1", tk)
+
+	if err := m.DeleteVolume("data"); err == nil {
+		t.Fatal("volume claimed by a running task was deleted")
+	}
+	if _, ok := m.GetVolume("data"); !ok {
+		t.Fatal("volume is gone after a refused delete")
+	}
+
+	m.mu.Lock()
+	m.TaskDB[tk.ID].State = task.Completed
+	m.mu.Unlock()
+	if err := m.DeleteVolume("data"); err != nil {
+		t.Fatalf("delete of a volume no task claims anymore failed: %v", err)
+	}
+	if _, ok := m.GetVolume("data"); ok {
+		t.Error("volume still exists after the delete")
+	}
+	if len(w.deletes) != 1 || w.deletes[0] != "/volumes/data" {
+		t.Errorf("worker got deletes %v, want the one of its data", w.deletes)
+	}
+	if err := m.DeleteVolume("data"); err != errVolumeNotFound {
+		t.Errorf("delete of a missing volume = %v, want errVolumeNotFound", err)
+	}
+}
@@ -1,13 +1,142 @@
 package node
 
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// node is the manager's view of a worker machine
+// name and api are used to reach the worker, the rest is used by the scheduler to find the best fit for a task
 type Node struct {
-	Name            string
-	IP              string
+	Name string
+	IP   string
+	Api  string
+	// memory and disk are in bytes, cpu is in millicores (1000 per core)
+	// cores, memory and disk are the capacity the worker registered with, the allocated ones are what the manager has handed out to tasks
 	Cores           int
+	CPUAllocated    int
 	Memory          int
 	MemoryAllocated int
-	Disk            int
-	DiskAllocated   int
-	Role            string
-	TaskCount       int
+	// the used ones, cpu usage and load are what the worker reported in its last stats
+	MemoryUsed    int
+	Disk          int
+	DiskAllocated int
+	DiskUsed      int
+	CPUUsage      float64
+	Load          float64
+	Role          string
+	TaskCount     int
+	// reported by the worker as well, they never change the allocations
+	RunningTasks   int
+	MemoryCapacity int
+	DiskCapacity   int
+	// the host ports taken by the tasks on the node, either asked for explicitly or handed out by the worker
+	// from its port range to the tasks which don't ask for a fixed one
+	Ports          []Port
+	PortRangeStart int
+	PortRangeEnd   int
+	LastUpdated    time.Time
+	// a node is healthy as long as its worker keeps sending heartbeats
+	Healthy       bool
+	LastHeartbeat time.Time
+}
+
+// a host port taken by a task, an empty ip (or 0.0.0.0) means every address of the node
+// an empty port is one the worker is still going to hand out from its port range
+// task is the id of the task which holds the port
+type Port struct {
+	IP       string
+	Port     string
+	Protocol string
+	Task     string `json:",omitempty"`
+}
+
+func (p Port) wildcard() bool {
+	ip := net.ParseIP(p.IP)
+	return p.IP == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// two tasks can't bind the same port and protocol unless they use different addresses
+func (p Port) Conflicts(o Port) bool {
+	if p.Port == "" || p.Port != o.Port || p.Protocol != o.Protocol {
+		return false
+	}
+	return p.wildcard() || o.wildcard() || p.IP == o.IP
+}
+
+// the fixed host ports of the bindings, bindings without a host port get a random one and can't conflict
+func PortsOf(bindings nat.PortMap) []Port {
+	var ports []Port
+	for p, bs := range bindings {
+		for _, b := range bs {
+			if b.HostPort == "" {
+				continue
+			}
+			ports = append(ports, Port{IP: b.HostIP, Port: b.HostPort, Protocol: p.Proto()})
+		}
+	}
+	return ports
+}
+
+// whether none of the ports is taken on the node
+func (n *Node) PortsFree(ports []Port) bool {
+	for _, p := range ports {
+		for _, used := range n.Ports {
+			if p.Conflicts(used) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (n *Node) ReservePorts(task string, ports []Port) {
+	for _, p := range ports {
+		p.Task = task
+		n.Ports = append(n.Ports, p)
+	}
+}
+
+// releases every port held by the task
+func (n *Node) ReleasePorts(task string) {
+	// a new slice, copies of the node may still share the old one
+	var ports []Port
+	for _, p := range n.Ports {
+		if p.Task != task {
+			ports = append(ports, p)
+		}
+	}
+	n.Ports = ports
+}
+
+// how many ports of the port range are still free for the protocol, -1 when the range is unknown
+func (n *Node) FreeRangePorts(protocol string) int {
+	if n.PortRangeStart <= 0 || n.PortRangeEnd < n.PortRangeStart {
+		return -1
+	}
+	taken := make(map[string]bool)
+	pending := 0
+	for _, p := range n.Ports {
+		if p.Port == "" && p.Protocol == protocol {
+			pending++
+			continue
+		}
+		port, err := strconv.Atoi(p.Port)
+		if err != nil || p.Protocol != protocol || port < n.PortRangeStart || port > n.PortRangeEnd {
+			continue
+		}
+		taken[p.Port] = true
+	}
+	return n.PortRangeEnd - n.PortRangeStart + 1 - len(taken) - pending
+}
+
+func NewNode(name string, api string, role string) *Node {
+	return &Node{
+		Name: name,
+		Api:  api,
+		Role: role,
+	}
 }
@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+// bin packing puts the task on the fullest node which can still fit it
+// this keeps the tasks on as few machines as possible so that idle ones can be drained and powered down
+type BinPacking struct {
+	Name string
+}
+
+func (b *BinPacking) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return withRoom(t, nodes)
+}
+
+// the score is the fraction of cpu, memory and disk which would be left free after placing the task
+// so the fuller the node the lower (better) the score
+func (b *BinPacking) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		cpuFree := 1.0
+		if n.Cores > 0 {
+			cpuFree = float64(n.Cores*1000-n.CPUAllocated-t.CPU) / float64(n.Cores*1000)
+		}
+		memFree := 1.0
+		if n.Memory > 0 {
+			memFree = float64(n.Memory-n.MemoryAllocated-t.MemoryRequest()) / float64(n.Memory)
+		}
+		diskFree := 1.0
+		if n.Disk > 0 {
+			diskFree = float64(n.Disk-n.DiskAllocated-t.Disk) / float64(n.Disk)
+		}
+		scores[n.Name] = (cpuFree + memFree + diskFree) / 3
+	}
+	return scores
+}
+
+func (b *BinPacking) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
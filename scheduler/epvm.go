@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"math"
+
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+// E-PVM (Enhanced Parallel Virtual Machine) scheduler
+// it calculates the marginal cost of putting the task on a node, for every resource the cost is
+// LIEB^(utilisation after the task) - LIEB^(utilisation before the task)
+// since the cost grows exponentially, a node which is already busy becomes expensive very fast
+// this is what keeps the small machines from getting overloaded
+// ref: https://mosix.cs.huji.ac.il/pub/ocja.pdf
+type Epvm struct {
+	Name string
+}
+
+// base used for the cost function
+const LIEB = 1.53960071783900203869
+
+// number of tasks a single core is expected to handle comfortably
+const maxTasksPerCore = 4.0
+
+func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return withRoom(t, nodes)
+}
+
+func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		cores := n.Cores
+		if cores < 1 {
+			cores = 1
+		}
+		capacity := maxTasksPerCore * float64(cores)
+		cpuBefore := float64(n.TaskCount) / capacity
+		// a node can be busy with work we don't know about, so trust the reported usage if it is higher
+		cpuBefore = max(cpuBefore, n.CPUUsage/100)
+		cpuAfter := cpuBefore + 1/capacity
+		// a task which asks for cpu costs what it asks for, if that is more than its share of a core
+		if t.CPU > 0 {
+			cpuBefore = max(cpuBefore, float64(n.CPUAllocated)/float64(cores*1000))
+			cpuAfter = max(cpuAfter, cpuBefore+float64(t.CPU)/float64(cores*1000))
+		}
+		cpuCost := math.Pow(LIEB, cpuAfter) - math.Pow(LIEB, cpuBefore)
+
+		var memCost float64
+		if n.Memory > 0 {
+			used := max(n.MemoryAllocated, n.MemoryUsed)
+			memBefore := float64(used) / float64(n.Memory)
+			memAfter := float64(used+t.MemoryRequest()) / float64(n.Memory)
+			memCost = math.Pow(LIEB, memAfter) - math.Pow(LIEB, memBefore)
+		}
+
+		scores[n.Name] = cpuCost + memCost
+	}
+	return scores
+}
+
+func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+// least loaded sends the task to the node which is doing the least amount of work
+// the load is the number of tasks per core plus the fraction of cpu and memory already handed out
+type LeastLoaded struct {
+	Name string
+}
+
+func (l *LeastLoaded) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return withRoom(t, nodes)
+}
+
+func (l *LeastLoaded) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		cores := n.Cores
+		if cores < 1 {
+			cores = 1
+		}
+		load := float64(n.TaskCount) / float64(cores)
+		if n.Cores > 0 {
+			load += float64(n.CPUAllocated) / float64(n.Cores*1000)
+		}
+		if n.Memory > 0 {
+			load += float64(n.MemoryAllocated) / float64(n.Memory)
+		}
+		scores[n.Name] = load
+	}
+	return scores
+}
+
+func (l *LeastLoaded) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+// round robin does not care about the machines at all, apart from whether the task fits on them
+// it just sends the task to the next worker in the list
+// the list is sorted by name and the name of the last worker is kept rather than its place,
+// so the rotation goes on from the right worker when the workers which have room change between tasks
+type RoundRobin struct {
+	Name       string
+	LastWorker string
+}
+
+func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return withRoom(t, nodes)
+}
+
+// the next worker after the one picked last gets the best score
+// scoring doesn't move the rotation, only a pick does
+func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(nodes) == 0 {
+		return scores
+	}
+
+	// the first name after the last worker, or the first of all once the end of the list is reached
+	first, next := "", ""
+	for _, n := range nodes {
+		if first == "" || n.Name < first {
+			first = n.Name
+		}
+		if n.Name > r.LastWorker && (next == "" || n.Name < next) {
+			next = n.Name
+		}
+	}
+	if next == "" {
+		next = first
+	}
+
+	for _, n := range nodes {
+		if n.Name == next {
+			scores[n.Name] = 0.1
+		} else {
+			scores[n.Name] = 1.0
+		}
+	}
+	return scores
+}
+
+func (r *RoundRobin) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	picked := pickLowest(scores, candidates)
+	if picked != nil {
+		r.LastWorker = picked.Name
+	}
+	return picked
+}
@@ -1,8 +1,155 @@
 package scheduler
 
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+// scheduling is done in three phases, quite similar to the kube-scheduler
+// SelectCandidateNodes filters out the nodes which can't run the task at all
+// Score gives every candidate a score, the lower the score the better the node is for the task
+// Pick chooses the node the task will be sent to
 type Scheduler interface {
-	SelectCandidateWorker()
-	Score()
-	Pick()
+	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
+	Score(t task.Task, nodes []*node.Node) map[string]float64
+	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
+}
+
+// types of scheduler which can be selected while creating the manager
+const (
+	RoundRobinType  = "roundrobin"
+	LeastLoadedType = "leastloaded"
+	EpvmType        = "epvm"
+	BinPackingType  = "binpacking"
+)
+
+func New(schedulerType string) (Scheduler, error) {
+	switch schedulerType {
+	case RoundRobinType:
+		return &RoundRobin{Name: RoundRobinType}, nil
+	case LeastLoadedType:
+		return &LeastLoaded{Name: LeastLoadedType}, nil
+	case EpvmType:
+		return &Epvm{Name: EpvmType}, nil
+	case BinPackingType:
+		return &BinPacking{Name: BinPackingType}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler type: %s", schedulerType)
+	}
+}
+
+// picks the candidate with the lowest score
+// if two nodes have the same score the one which comes first wins
+func pickLowest(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	var lowest float64
+	for _, n := range candidates {
+		score, ok := scores[n.Name]
+		if !ok {
+			continue
+		}
+		if best == nil || score < lowest {
+			best = n
+			lowest = score
+		}
+	}
+	return best
+}
+
+// the host ports the task takes on its node, the fixed ones it asks for and the ones its worker reported
+// a port the worker hasn't reported yet is left empty, so that it still counts against the port range of the node
+// a process binds its ports on the host as they are
+func HostPorts(t task.Task) []node.Port {
+	pm, err := t.PortMap()
+	if err != nil {
+		return nil
+	}
+	if t.Runtime == task.RuntimeProcess {
+		for p, bs := range pm {
+			for i := range bs {
+				bs[i].HostPort = p.Port()
+			}
+		}
+	}
+	ports := node.PortsOf(pm)
+	for p, bs := range pm {
+		for _, b := range bs {
+			if b.HostPort == "" && len(t.HostPort[p]) == 0 {
+				ports = append(ports, node.Port{Protocol: p.Proto()})
+			}
+		}
+	}
+	for _, p := range node.PortsOf(t.HostPort) {
+		if !slices.Contains(ports, p) {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// the number of ports per protocol the worker has to hand out from its port range
+func rangePorts(t task.Task) map[string]int {
+	if t.Runtime == task.RuntimeProcess {
+		return nil
+	}
+	pm, err := t.PortMap()
+	if err != nil {
+		return nil
+	}
+	needed := make(map[string]int)
+	for p, bs := range pm {
+		for _, b := range bs {
+			if b.HostPort == "" {
+				needed[p.Proto()]++
+			}
+		}
+	}
+	return needed
+}
+
+// whether the fixed ports of the task are free on the node and its port range has room for the rest
+func portsFit(t task.Task, n *node.Node) bool {
+	if !n.PortsFree(HostPorts(t)) {
+		return false
+	}
+	for proto, count := range rangePorts(t) {
+		if free := n.FreeRangePorts(proto); free >= 0 && free < count {
+			return false
+		}
+	}
+	return true
+}
+
+// the nodes which have room for the task
+func withRoom(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if fits(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
 }
 
+// checks whether the node has enough free cpu, memory, disk and host ports for the task
+// a node which has not reported its capacity yet (zero) is not filtered out
+// the memory a task needs is its limit, or its reservation when it has no limit
+func fits(t task.Task, n *node.Node) bool {
+	if n.Cores > 0 && n.Cores*1000-n.CPUAllocated < t.CPU {
+		return false
+	}
+	if n.Memory > 0 && n.Memory-n.MemoryAllocated < t.MemoryRequest() {
+		return false
+	}
+	if n.Disk > 0 && n.Disk-n.DiskAllocated < t.Disk {
+		return false
+	}
+	// two tasks can't share a host port
+	if !portsFit(t, n) {
+		return false
+	}
+	return true
+}
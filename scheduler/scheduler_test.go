@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+
+	"github.com/hanshal101/core/node"
+	"github.com/hanshal101/core/task"
+)
+
+const gb = 1 << 30
+
+func names(nodes []*node.Node) []string {
+	var ns []string
+	for _, n := range nodes {
+		ns = append(ns, n.Name)
+	}
+	return ns
+}
+
+// runs the three phases like the manager does
+func schedule(s Scheduler, t task.Task, nodes []*node.Node) *node.Node {
+	candidates := s.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		return nil
+	}
+	return s.Pick(s.Score(t, candidates), candidates)
+}
+
+func TestNew(t *testing.T) {
+	for _, typ := range []string{RoundRobinType, LeastLoadedType, EpvmType, BinPackingType} {
+		if _, err := New(typ); err != nil {
+			t.Errorf("New(%q) failed: %v", typ, err)
+		}
+	}
+	if _, err := New("random"); err == nil {
+		t.Error("New accepted an unknown scheduler type")
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		name string
+		task task.Task
+		node node.Node
+		fits bool
+	}{
+		{"empty node", task.Task{CPU: 500, Memory: gb, Disk: gb}, node.Node{Cores: 1, Memory: 2 * gb, Disk: 2 * gb}, true},
+		{"capacity not reported yet", task.Task{CPU: 4000, Memory: 8 * gb, Disk: 8 * gb}, node.Node{}, true},
+		{"not enough cpu", task.Task{CPU: 1500}, node.Node{Cores: 2, CPUAllocated: 1000}, false},
+		{"cpu just fits", task.Task{CPU: 1000}, node.Node{Cores: 2, CPUAllocated: 1000}, true},
+		{"not enough memory", task.Task{Memory: 2 * gb}, node.Node{Memory: 4 * gb, MemoryAllocated: 3 * gb}, false},
+		{"reservation counts without a limit", task.Task{MemoryReservation: 2 * gb}, node.Node{Memory: 4 * gb, MemoryAllocated: 3 * gb}, false},
+		{"not enough disk", task.Task{Disk: 2 * gb}, node.Node{Disk: 4 * gb, DiskAllocated: 3 * gb}, false},
+		{
+			"host port taken",
+			task.Task{PortBindings: map[string]string{"80/tcp": "8080"}},
+			node.Node{Ports: []node.Port{{Port: "8080", Protocol: "tcp"}}},
+			false,
+		},
+		{
+			"host port taken for another protocol",
+			task.Task{PortBindings: map[string]string{"53/udp": "8053"}},
+			node.Node{Ports: []node.Port{{Port: "8053", Protocol: "tcp"}}},
+			true,
+		},
+		{
+			"port range used up",
+			task.Task{ExposedPorts: nat.PortSet{"80/tcp": {}}},
+			node.Node{PortRangeStart: 30000, PortRangeEnd: 30000, Ports: []node.Port{{Port: "30000", Protocol: "tcp"}}},
+			false,
+		},
+		{
+			"port range has room",
+			task.Task{ExposedPorts: nat.PortSet{"80/tcp": {}}},
+			node.Node{PortRangeStart: 30000, PortRangeEnd: 30001, Ports: []node.Port{{Port: "30000", Protocol: "tcp"}}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fits(tt.task, &tt.node); got != tt.fits {
+				t.Errorf("fits() = %v, want %v", got, tt.fits)
+			}
+		})
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	rr := &RoundRobin{Name: RoundRobinType}
+	nodes := []*node.Node{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, schedule(rr, task.Task{}, nodes).Name)
+	}
+	if got, want := picked, []string{"a", "b", "c", "a"}; !slices.Equal(got, want) {
+		t.Errorf("picked %v, want %v", got, want)
+	}
+
+	// scores which are thrown away don't move the rotation
+	rr.Score(task.Task{}, nodes)
+	rr.Score(task.Task{}, nodes)
+	if n := schedule(rr, task.Task{}, nodes); n.Name != "b" {
+		t.Errorf("picked %s after scoring only, want b", n.Name)
+	}
+}
+
+func TestRoundRobinResumesByName(t *testing.T) {
+	a, b, c, d := &node.Node{Name: "a"}, &node.Node{Name: "b"}, &node.Node{Name: "c"}, &node.Node{Name: "d"}
+	tests := []struct {
+		name  string
+		last  string
+		nodes []*node.Node
+		want  string
+	}{
+		{"first pick", "", []*node.Node{c, a, b}, "a"},
+		{"next name", "a", []*node.Node{a, b, c}, "b"},
+		{"order of the nodes doesn't matter", "a", []*node.Node{c, b, a}, "b"},
+		// the place of the last worker in the list moved, its name didn't
+		{"a worker before the last one is gone", "b", []*node.Node{b, c}, "c"},
+		{"the last worker is gone", "b", []*node.Node{a, c, d}, "c"},
+		{"a worker joined after the last one", "b", []*node.Node{a, b, d, c}, "c"},
+		{"wraps around", "c", []*node.Node{a, b, c}, "a"},
+		{"the last worker was the last name", "d", []*node.Node{b, c}, "b"},
+		{"only one worker", "a", []*node.Node{a}, "a"},
+	}
+	for _, tt := range tests {
+		rr := &RoundRobin{Name: RoundRobinType, LastWorker: tt.last}
+		n := schedule(rr, task.Task{}, tt.nodes)
+		if n == nil || n.Name != tt.want {
+			t.Errorf("%s: picked %v, want %s", tt.name, n, tt.want)
+			continue
+		}
+		if rr.LastWorker != tt.want {
+			t.Errorf("%s: last worker is %s, want %s", tt.name, rr.LastWorker, tt.want)
+		}
+	}
+}
+
+func TestRoundRobinSkipsFullNodes(t *testing.T) {
+	rr := &RoundRobin{Name: RoundRobinType}
+	nodes := []*node.Node{
+		{Name: "a", Memory: gb},
+		{Name: "full", Memory: gb, MemoryAllocated: gb},
+		{Name: "b", Memory: gb},
+	}
+	for i := 0; i < 4; i++ {
+		if n := schedule(rr, task.Task{Memory: gb / 2}, nodes); n == nil || n.Name == "full" {
+			t.Fatalf("picked %v, want a node with room", n)
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	ll := &LeastLoaded{Name: LeastLoadedType}
+	nodes := []*node.Node{
+		{Name: "busy", Cores: 2, Memory: 4 * gb, TaskCount: 4, CPUAllocated: 1500, MemoryAllocated: 3 * gb},
+		{Name: "idle", Cores: 2, Memory: 4 * gb, TaskCount: 1, CPUAllocated: 250, MemoryAllocated: gb},
+	}
+	if n := schedule(ll, task.Task{}, nodes); n.Name != "idle" {
+		t.Errorf("picked %s, want idle", n.Name)
+	}
+
+	// a node which hasn't reported its capacity counts as a single core
+	nodes = []*node.Node{
+		{Name: "unknown", TaskCount: 3},
+		{Name: "reported", Cores: 4, TaskCount: 3},
+	}
+	if n := schedule(ll, task.Task{}, nodes); n.Name != "reported" {
+		t.Errorf("picked %s, want reported", n.Name)
+	}
+}
+
+func TestEpvm(t *testing.T) {
+	e := &Epvm{Name: EpvmType}
+	nodes := []*node.Node{
+		{Name: "small", Cores: 1, Memory: 2 * gb, TaskCount: 2, MemoryAllocated: gb},
+		{Name: "big", Cores: 8, Memory: 16 * gb, TaskCount: 2, MemoryAllocated: gb},
+	}
+	if n := schedule(e, task.Task{Memory: gb / 2}, nodes); n.Name != "big" {
+		t.Errorf("picked %s, want big", n.Name)
+	}
+
+	// the reported usage wins when it is higher than what the manager handed out
+	nodes = []*node.Node{
+		{Name: "hot", Cores: 4, CPUUsage: 90},
+		{Name: "cool", Cores: 4, TaskCount: 2},
+	}
+	if n := schedule(e, task.Task{}, nodes); n.Name != "cool" {
+		t.Errorf("picked %s, want cool", n.Name)
+	}
+
+	// zero capacity is treated as a single core and no memory cost
+	scores := e.Score(task.Task{Memory: gb}, []*node.Node{{Name: "zero"}})
+	if _, ok := scores["zero"]; !ok {
+		t.Error("node without capacity got no score")
+	}
+}
+
+func TestBinPacking(t *testing.T) {
+	b := &BinPacking{Name: BinPackingType}
+	nodes := []*node.Node{
+		{Name: "empty", Cores: 4, Memory: 8 * gb, Disk: 100 * gb},
+		{Name: "half", Cores: 4, Memory: 8 * gb, Disk: 100 * gb, CPUAllocated: 2000, MemoryAllocated: 4 * gb, DiskAllocated: 50 * gb},
+		{Name: "full", Cores: 4, Memory: 8 * gb, Disk: 100 * gb, CPUAllocated: 3500, MemoryAllocated: 7 * gb, DiskAllocated: 90 * gb},
+	}
+	tk := task.Task{CPU: 1000, Memory: 2 * gb, Disk: 10 * gb}
+
+	// the fullest node can't take the task, so it isn't a candidate at all
+	candidates := b.SelectCandidateNodes(tk, nodes)
+	if got, want := names(candidates), []string{"empty", "half"}; !slices.Equal(got, want) {
+		t.Fatalf("candidates %v, want %v", got, want)
+	}
+	if n := b.Pick(b.Score(tk, candidates), candidates); n.Name != "half" {
+		t.Errorf("picked %s, want half", n.Name)
+	}
+
+	// once the task fits on the fullest node it goes there
+	tk = task.Task{CPU: 500, Memory: gb / 2, Disk: gb}
+	if n := schedule(b, tk, nodes); n.Name != "full" {
+		t.Errorf("picked %s, want full", n.Name)
+	}
+
+	// a node which hasn't reported its capacity looks empty
+	nodes = append(nodes, &node.Node{Name: "zero"})
+	scores := b.Score(tk, nodes)
+	if scores["zero"] != 1 {
+		t.Errorf("score of node without capacity is %v, want 1", scores["zero"])
+	}
+	if n := schedule(b, tk, nodes); n.Name != "full" {
+		t.Errorf("picked %s, want full", n.Name)
+	}
+
+	if n := schedule(b, task.Task{Memory: 16 * gb}, nodes[:3]); n != nil {
+		t.Errorf("picked %s for a task which fits nowhere", n.Name)
+	}
+}
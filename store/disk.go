@@ -0,0 +1,222 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+// number of writes after which the wal is compacted into a new snapshot
+const snapshotEvery = 1000
+
+// durable store which lives in a directory on disk
+// every write is appended to a write-ahead log (and synced) before it is applied to the in-memory copy
+// from time to time the whole state is written to a snapshot and the log is truncated
+// on open the snapshot is loaded and the log is replayed on top of it
+type Disk struct {
+	mu     sync.Mutex
+	dir    string
+	wal    *os.File
+	writes int
+	*Memory
+}
+
+// a single entry of the write-ahead log
+type walEntry struct {
+	Op     string
+	Bucket string
+	Key    string
+	Value  json.RawMessage `json:",omitempty"`
+}
+
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error in creating store directory %s: %v", dir, err)
+	}
+
+	d := &Disk{
+		dir:    dir,
+		Memory: NewMemory(),
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := d.replay(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error in opening wal: %v", err)
+	}
+	d.wal = wal
+	return d, nil
+}
+
+func (d *Disk) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(d.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error in reading snapshot: %v", err)
+	}
+
+	snapshot := make(map[string]map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("error in decoding snapshot: %v", err)
+	}
+	for bucket, values := range snapshot {
+		for key, value := range values {
+			d.Memory.put(bucket, key, value)
+		}
+	}
+	return nil
+}
+
+// a crash in the middle of a write leaves the last entry half written, without its newline
+// it is cut off, otherwise the next entry would be appended to it and get lost on the next replay
+func (d *Disk) replay() error {
+	f, err := os.OpenFile(filepath.Join(d.dir, walFile), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error in opening wal: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Cutting off the half written last wal entry (%d bytes)\n", len(line))
+				if err := f.Truncate(good); err != nil {
+					return fmt.Errorf("error in truncating wal: %v", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error in reading wal: %v", err)
+		}
+		good += int64(len(line))
+
+		var e walEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("Skipping corrupted wal entry: %v\n", err)
+			continue
+		}
+		d.apply(e)
+	}
+}
+
+func (d *Disk) apply(e walEntry) {
+	switch e.Op {
+	case "put":
+		d.Memory.put(e.Bucket, e.Key, e.Value)
+	case "delete":
+		d.Memory.Delete(e.Bucket, e.Key)
+	}
+}
+
+func (d *Disk) write(e walEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of wal entry: %v", err)
+	}
+	if _, err := d.wal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error in writing wal: %v", err)
+	}
+	if err := d.wal.Sync(); err != nil {
+		return fmt.Errorf("error in syncing wal: %v", err)
+	}
+	d.apply(e)
+
+	d.writes++
+	if d.writes >= snapshotEvery {
+		if err := d.snapshot(); err != nil {
+			log.Printf("Error in taking snapshot: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func (d *Disk) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of %s/%s: %v", bucket, key, err)
+	}
+	return d.write(walEntry{Op: "put", Bucket: bucket, Key: key, Value: data})
+}
+
+func (d *Disk) Delete(bucket string, key string) error {
+	return d.write(walEntry{Op: "delete", Bucket: bucket, Key: key})
+}
+
+// writes the whole state to a new snapshot and empties the wal
+// the snapshot is written to a temporary file first so a crash never leaves a half written snapshot behind
+func (d *Disk) snapshot() error {
+	d.Memory.mu.RLock()
+	state := make(map[string]map[string]json.RawMessage, len(d.Memory.data))
+	for bucket, values := range d.Memory.data {
+		state[bucket] = make(map[string]json.RawMessage, len(values))
+		for key, value := range values {
+			state[bucket][key] = value
+		}
+	}
+	d.Memory.mu.RUnlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of snapshot: %v", err)
+	}
+
+	tmp := filepath.Join(d.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error in creating snapshot: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error in writing snapshot: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error in syncing snapshot: %v", err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, filepath.Join(d.dir, snapshotFile)); err != nil {
+		return fmt.Errorf("error in replacing snapshot: %v", err)
+	}
+
+	if err := d.wal.Truncate(0); err != nil {
+		return fmt.Errorf("error in truncating wal: %v", err)
+	}
+	d.writes = 0
+	return nil
+}
+
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.snapshot(); err != nil {
+		log.Printf("Error in taking snapshot: %v\n", err)
+	}
+	return d.wal.Close()
+}
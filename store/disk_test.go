@@ -0,0 +1,161 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type record struct {
+	Name  string
+	Count int
+}
+
+func openDisk(t *testing.T, dir string) *Disk {
+	t.Helper()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatalf("error in opening the store: %v", err)
+	}
+	return d
+}
+
+// the whole content of the bucket, decoded
+func records(t *testing.T, s Store, bucket string) map[string]record {
+	t.Helper()
+	values, err := s.List(bucket)
+	if err != nil {
+		t.Fatalf("error in listing %s: %v", bucket, err)
+	}
+	rs := make(map[string]record)
+	for k := range values {
+		var r record
+		if err := s.Get(bucket, k, &r); err != nil {
+			t.Fatalf("error in getting %s/%s: %v", bucket, k, err)
+		}
+		rs[k] = r
+	}
+	return rs
+}
+
+// reopening without a clean close, the state comes back from the wal alone
+func TestDiskReplaysWal(t *testing.T) {
+	dir := t.TempDir()
+	d := openDisk(t, dir)
+	d.Put("tasks", "a", record{Name: "a", Count: 1})
+	d.Put("tasks", "b", record{Name: "b", Count: 2})
+	d.Put("tasks", "a", record{Name: "a", Count: 3})
+	d.Put("workers", "w1", record{Name: "w1"})
+	d.Delete("tasks", "b")
+	want := records(t, d, "tasks")
+	d.wal.Close()
+
+	reopened := openDisk(t, dir)
+	defer reopened.Close()
+	if got := records(t, reopened, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks after replay = %v, want %v", got, want)
+	}
+	if got := records(t, reopened, "workers"); len(got) != 1 {
+		t.Errorf("workers after replay = %v, want w1", got)
+	}
+	var r record
+	if err := reopened.Get("tasks", "b", &r); err != ErrNotFound {
+		t.Errorf("deleted key came back: %v %v", r, err)
+	}
+}
+
+func TestDiskCompactsIntoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	d := openDisk(t, dir)
+	for i := 0; i < snapshotEvery+10; i++ {
+		d.Put("tasks", fmt.Sprintf("t%d", i%100), record{Name: "t", Count: i})
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("no snapshot after %d writes: %v", snapshotEvery, err)
+	}
+	if d.writes != 10 {
+		t.Errorf("%d writes since the snapshot, want 10", d.writes)
+	}
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2048 {
+		t.Errorf("wal has %d bytes after the snapshot, it wasn't truncated", info.Size())
+	}
+	want := records(t, d, "tasks")
+	d.wal.Close()
+
+	// the snapshot and the writes after it
+	reopened := openDisk(t, dir)
+	if got := records(t, reopened, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks after reopen differ, got %d keys want %d", len(got), len(want))
+	}
+	if got := records(t, reopened, "tasks")["t9"].Count; got != snapshotEvery+9 {
+		t.Errorf("t9 has count %d, want %d", got, snapshotEvery+9)
+	}
+
+	// closing takes a snapshot, so nothing is left to replay
+	reopened.Put("tasks", "last", record{Name: "last"})
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, walFile)); info.Size() != 0 {
+		t.Errorf("wal has %d bytes after close, want 0", info.Size())
+	}
+	again := openDisk(t, dir)
+	defer again.Close()
+	if _, ok := records(t, again, "tasks")["last"]; !ok {
+		t.Error("write before close is lost")
+	}
+}
+
+func TestDiskTornWalRecord(t *testing.T) {
+	dir := t.TempDir()
+	d := openDisk(t, dir)
+	d.Put("tasks", "a", record{Name: "a", Count: 1})
+	d.Put("tasks", "b", record{Name: "b", Count: 2})
+	d.wal.Close()
+
+	// we crashed in the middle of writing the third entry
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Op":"put","Bucket":"tasks","Key":"c","Value":{"Na`)
+	f.Close()
+
+	reopened := openDisk(t, dir)
+	want := map[string]record{"a": {Name: "a", Count: 1}, "b": {Name: "b", Count: 2}}
+	if got := records(t, reopened, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks after torn write = %v, want %v", got, want)
+	}
+
+	// the write after the crash must not end up glued to the torn one
+	reopened.Put("tasks", "d", record{Name: "d", Count: 4})
+	reopened.wal.Close()
+
+	again := openDisk(t, dir)
+	defer again.Close()
+	want["d"] = record{Name: "d", Count: 4}
+	if got := records(t, again, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks after the next reopen = %v, want %v", got, want)
+	}
+}
+
+func TestDiskSkipsCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, walFile), []byte(
+		`{"Op":"put","Bucket":"tasks","Key":"a","Value":{"Name":"a"}}`+"\n"+
+			"garbage\n"+
+			`{"Op":"put","Bucket":"tasks","Key":"b","Value":{"Name":"b"}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := openDisk(t, dir)
+	defer d.Close()
+	if got := records(t, d, "tasks"); len(got) != 2 {
+		t.Errorf("tasks = %v, want a and b", got)
+	}
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcd backed store, this lets several managers and workers share the state of the cluster
+// keys are laid out as <prefix>/<bucket>/<key>, e.g. /core/manager/tasks/<task id>
+// every write bumps the revision of the key, which can be used for optimistic concurrency with PutIfRevision
+// and to pick up a Watch where it left off
+// once the store is fenced its writes only go through while the fence key is the one it was fenced with
+type Etcd struct {
+	Client  *clientv3.Client
+	Prefix  string
+	Timeout time.Duration
+	fence   atomic.Pointer[clientv3.Cmp]
+}
+
+func NewEtcd(endpoints []string, prefix string) (*Etcd, error) {
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in connecting to etcd at %v: %v", endpoints, err)
+	}
+	return NewEtcdFromClient(c, prefix), nil
+}
+
+// useful when the client is already there, e.g. one connected to an embedded etcd server
+func NewEtcdFromClient(c *clientv3.Client, prefix string) *Etcd {
+	return &Etcd{
+		Client:  c,
+		Prefix:  strings.TrimSuffix(prefix, "/"),
+		Timeout: 5 * time.Second,
+	}
+}
+
+func (e *Etcd) bucketKey(bucket string) string {
+	return fmt.Sprintf("%s/%s/", e.Prefix, bucket)
+}
+
+func (e *Etcd) key(bucket string, key string) string {
+	return e.bucketKey(bucket) + key
+}
+
+// from now on every write only goes through while key still has the create revision rev
+// a leader fences its writes with its election key, once it is deposed its key is gone (or a new one took its place)
+// and the writes fail with ErrFenced instead of overwriting what the new leader wrote
+func (e *Etcd) Fence(key string, rev int64) {
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", rev)
+	e.fence.Store(&cmp)
+}
+
+// applies the write, within a transaction checking the fence if there is one
+func (e *Etcd) write(op clientv3.Op) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	fence := e.fence.Load()
+	if fence == nil {
+		_, err := e.Client.Do(ctx, op)
+		return err
+	}
+	resp, err := e.Client.Txn(ctx).If(*fence).Then(op).Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrFenced
+	}
+	return nil
+}
+
+func (e *Etcd) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of %s/%s: %v", bucket, key, err)
+	}
+
+	if err := e.write(clientv3.OpPut(e.key(bucket, key), string(data))); err != nil {
+		if err == ErrFenced {
+			return err
+		}
+		return fmt.Errorf("error in putting %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (e *Etcd) Get(bucket string, key string, value interface{}) error {
+	_, err := e.GetRevision(bucket, key, value)
+	return err
+}
+
+// same as Get but also returns the revision at which the key was last modified
+func (e *Etcd) GetRevision(bucket string, key string, value interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	resp, err := e.Client.Get(ctx, e.key(bucket, key))
+	if err != nil {
+		return 0, fmt.Errorf("error in getting %s/%s: %v", bucket, key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, ErrNotFound
+	}
+
+	kv := resp.Kvs[0]
+	if err := json.Unmarshal(kv.Value, value); err != nil {
+		return 0, fmt.Errorf("error in json unmarshal of %s/%s: %v", bucket, key, err)
+	}
+	return kv.ModRevision, nil
+}
+
+// writes the value only if nobody else modified the key since the given revision
+// a revision of 0 means the key must not exist yet, ErrConflict is returned when the check fails
+func (e *Etcd) PutIfRevision(bucket string, key string, value interface{}, revision int64) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of %s/%s: %v", bucket, key, err)
+	}
+
+	k := e.key(bucket, key)
+	put := clientv3.OpTxn(
+		[]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(k), "=", revision)},
+		[]clientv3.Op{clientv3.OpPut(k, string(data))},
+		nil,
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	// the fence is checked first, so a deposed leader gets ErrFenced and not ErrConflict
+	var cmps []clientv3.Cmp
+	if fence := e.fence.Load(); fence != nil {
+		cmps = append(cmps, *fence)
+	}
+	resp, err := e.Client.Txn(ctx).If(cmps...).Then(put).Commit()
+	if err != nil {
+		return fmt.Errorf("error in putting %s/%s: %v", bucket, key, err)
+	}
+	if !resp.Succeeded {
+		return ErrFenced
+	}
+	if !resp.Responses[0].GetResponseTxn().Succeeded {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (e *Etcd) Delete(bucket string, key string) error {
+	if err := e.write(clientv3.OpDelete(e.key(bucket, key))); err != nil {
+		if err == ErrFenced {
+			return err
+		}
+		return fmt.Errorf("error in deleting %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (e *Etcd) List(bucket string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	prefix := e.bucketKey(bucket)
+	resp, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("error in listing %s: %v", bucket, err)
+	}
+
+	values := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		values[strings.TrimPrefix(string(kv.Key), prefix)] = kv.Value
+	}
+	return values, nil
+}
+
+// sends every change made to the buckets (by anyone) after revision rev, until the context is cancelled
+// rev is the revision the caller has seen last, so nothing it missed while it wasn't watching is lost
+// a rev of 0 only sends the changes made from now on, without buckets every bucket is watched
+func (e *Etcd) Watch(ctx context.Context, rev int64, buckets ...string) <-chan Event {
+	events := make(chan Event)
+	prefix := e.Prefix + "/"
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+	watched := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		watched[b] = true
+	}
+	go func() {
+		defer close(events)
+		for resp := range e.Client.Watch(ctx, prefix, opts...) {
+			if err := resp.Err(); err != nil {
+				log.Printf("Error in watching %s: %v\n", prefix, err)
+				return
+			}
+			for _, ev := range resp.Events {
+				bucket, key, ok := strings.Cut(strings.TrimPrefix(string(ev.Kv.Key), prefix), "/")
+				if !ok || (len(watched) > 0 && !watched[bucket]) {
+					continue
+				}
+				event := Event{
+					Type:     EventPut,
+					Bucket:   bucket,
+					Key:      key,
+					Value:    ev.Kv.Value,
+					Revision: ev.Kv.ModRevision,
+				}
+				if ev.Type == clientv3.EventTypeDelete {
+					event.Type = EventDelete
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func (e *Etcd) Close() error {
+	return e.Client.Close()
+}
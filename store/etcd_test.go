@@ -0,0 +1,254 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/hanshal101/core/internal/etcdtest"
+)
+
+func TestEtcd(t *testing.T) {
+	e := NewEtcdFromClient(etcdtest.Start(t), "/core/test/")
+
+	if err := e.Put("tasks", "a", record{Name: "a", Count: 1}); err != nil {
+		t.Fatalf("error in putting: %v", err)
+	}
+	e.Put("tasks", "b", record{Name: "b", Count: 2})
+	e.Put("workers", "w1", record{Name: "w1"})
+
+	var r record
+	if err := e.Get("tasks", "a", &r); err != nil || r != (record{Name: "a", Count: 1}) {
+		t.Errorf("Get = %v, %v", r, err)
+	}
+	if err := e.Get("tasks", "missing", &r); err != ErrNotFound {
+		t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+	}
+
+	// the buckets don't leak into each other
+	want := map[string]record{"a": {Name: "a", Count: 1}, "b": {Name: "b", Count: 2}}
+	if got := records(t, e, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("List(tasks) = %v, want %v", got, want)
+	}
+
+	// the keys are laid out under the prefix
+	resp, err := e.Client.Get(context.Background(), "/core/test/workers/w1")
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Errorf("worker not stored under /core/test/workers/w1: %v %v", resp, err)
+	}
+
+	if err := e.Delete("tasks", "b"); err != nil {
+		t.Fatalf("error in deleting: %v", err)
+	}
+	delete(want, "b")
+	if got := records(t, e, "tasks"); !reflect.DeepEqual(got, want) {
+		t.Errorf("List(tasks) after delete = %v, want %v", got, want)
+	}
+}
+
+func TestEtcdRevisions(t *testing.T) {
+	e := NewEtcdFromClient(etcdtest.Start(t), "/core/test")
+
+	e.Put("tasks", "a", record{Name: "a", Count: 1})
+	var r record
+	first, err := e.GetRevision("tasks", "a", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a write to another key bumps the revision of the store but not of the key
+	e.Put("tasks", "b", record{Name: "b"})
+	if rev, _ := e.GetRevision("tasks", "a", &r); rev != first {
+		t.Errorf("revision of a moved from %d to %d without a write", first, rev)
+	}
+
+	e.Put("tasks", "a", record{Name: "a", Count: 2})
+	second, err := e.GetRevision("tasks", "a", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first || r.Count != 2 {
+		t.Errorf("after a write revision is %d (was %d) and count %d", second, first, r.Count)
+	}
+
+	if _, err := e.GetRevision("tasks", "missing", &r); err != ErrNotFound {
+		t.Errorf("GetRevision of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEtcdSharedState(t *testing.T) {
+	c := etcdtest.Start(t)
+	manager := NewEtcdFromClient(c, "/core/manager")
+	other := NewEtcdFromClient(c, "/core/manager")
+	worker := NewEtcdFromClient(c, "/core/workers/w1")
+
+	for i := 0; i < 3; i++ {
+		manager.Put("tasks", fmt.Sprintf("t%d", i), record{Count: i})
+	}
+	if got := records(t, other, "tasks"); len(got) != 3 {
+		t.Errorf("another component sees %d tasks, want 3", len(got))
+	}
+	if got := records(t, worker, "tasks"); len(got) != 0 {
+		t.Errorf("a store with another prefix sees %v", got)
+	}
+}
+
+func TestEtcdFence(t *testing.T) {
+	c := etcdtest.Start(t)
+	e := NewEtcdFromClient(c, "/core/manager")
+	ctx := context.Background()
+
+	lease, err := c.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Put(ctx, "/core/election/1", "m1", clientv3.WithLease(lease.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Fence("/core/election/1", resp.Header.Revision)
+
+	if err := e.Put("tasks", "a", record{Name: "a", Count: 1}); err != nil {
+		t.Fatalf("write of the leader failed: %v", err)
+	}
+	if err := e.Delete("tasks", "a"); err != nil {
+		t.Fatalf("delete of the leader failed: %v", err)
+	}
+	e.Put("tasks", "a", record{Name: "a", Count: 1})
+
+	// the lease of the leader is gone, and with it its election key
+	if _, err := c.Revoke(ctx, lease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Put("tasks", "a", record{Name: "a", Count: 2}); err != ErrFenced {
+		t.Errorf("write of the deposed leader = %v, want ErrFenced", err)
+	}
+	if err := e.Delete("tasks", "a"); err != ErrFenced {
+		t.Errorf("delete of the deposed leader = %v, want ErrFenced", err)
+	}
+	if err := e.PutIfRevision("tasks", "a", record{Name: "a", Count: 2}, 0); err != ErrFenced {
+		t.Errorf("conditional write of the deposed leader = %v, want ErrFenced", err)
+	}
+
+	// a key of the same name written by someone else doesn't lift the fence
+	c.Put(ctx, "/core/election/1", "m2")
+	if err := e.Put("tasks", "a", record{Name: "a", Count: 3}); err != ErrFenced {
+		t.Errorf("write after the key was taken over = %v, want ErrFenced", err)
+	}
+
+	var r record
+	if err := NewEtcdFromClient(c, "/core/manager").Get("tasks", "a", &r); err != nil || r.Count != 1 {
+		t.Errorf("task a = %v, %v, want the last write of the leader", r, err)
+	}
+}
+
+func TestEtcdPutIfRevision(t *testing.T) {
+	e := NewEtcdFromClient(etcdtest.Start(t), "/core/test")
+
+	if err := e.PutIfRevision("tasks", "a", record{Count: 1}, 0); err != nil {
+		t.Fatalf("create of a missing key failed: %v", err)
+	}
+	if err := e.PutIfRevision("tasks", "a", record{Count: 2}, 0); err != ErrConflict {
+		t.Errorf("create of an existing key = %v, want ErrConflict", err)
+	}
+
+	var r record
+	rev, _ := e.GetRevision("tasks", "a", &r)
+	// someone else wrote the key after we read it
+	e.Put("tasks", "a", record{Count: 3})
+	if err := e.PutIfRevision("tasks", "a", record{Count: 4}, rev); err != ErrConflict {
+		t.Errorf("write on a stale revision = %v, want ErrConflict", err)
+	}
+	rev, _ = e.GetRevision("tasks", "a", &r)
+	if err := e.PutIfRevision("tasks", "a", record{Count: 5}, rev); err != nil {
+		t.Errorf("write on the current revision failed: %v", err)
+	}
+	if e.Get("tasks", "a", &r); r.Count != 5 {
+		t.Errorf("count is %d, want 5", r.Count)
+	}
+}
+
+// the next event of the watch, or a failure if none comes
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("watch ended")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event on the watch")
+	}
+	return Event{}
+}
+
+// the revision the store is at, a watch from there sees everything which happens afterwards
+// a watch from 0 only starts once etcd set it up, which may be after the next writes
+func revision(t *testing.T, e *Etcd) int64 {
+	t.Helper()
+	resp, err := e.Client.Get(context.Background(), e.Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Revision
+}
+
+func TestEtcdWatch(t *testing.T) {
+	e := NewEtcdFromClient(etcdtest.Start(t), "/core/manager")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := e.Watch(ctx, revision(t, e), "tasks", "assignments")
+
+	e.Put("tasks", "a", record{Name: "a"})
+	// not a watched bucket
+	e.Put("workers", "w1", record{Name: "w1"})
+	e.Put("assignments", "a", "w1")
+	e.Delete("tasks", "a")
+
+	put := nextEvent(t, events)
+	if put.Type != EventPut || put.Bucket != "tasks" || put.Key != "a" || string(put.Value) != `{"Name":"a","Count":0}` {
+		t.Errorf("first event = %+v, want the put of task a", put)
+	}
+	if ev := nextEvent(t, events); ev.Bucket != "assignments" || ev.Key != "a" || ev.Type != EventPut {
+		t.Errorf("second event = %+v, want the put of assignment a", ev)
+	}
+	del := nextEvent(t, events)
+	if del.Type != EventDelete || del.Bucket != "tasks" || del.Key != "a" || len(del.Value) != 0 {
+		t.Errorf("third event = %+v, want the delete of task a", del)
+	}
+	if del.Revision <= put.Revision {
+		t.Errorf("delete at revision %d, put at %d", del.Revision, put.Revision)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestEtcdWatchResumes(t *testing.T) {
+	e := NewEtcdFromClient(etcdtest.Start(t), "/core/manager")
+	ctx, cancel := context.WithCancel(context.Background())
+	events := e.Watch(ctx, revision(t, e), "tasks")
+	e.Put("tasks", "a", record{Count: 1})
+	seen := nextEvent(t, events)
+	cancel()
+
+	// these happen while nobody is watching
+	e.Put("tasks", "b", record{Count: 2})
+	e.Delete("tasks", "a")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events = e.Watch(ctx, seen.Revision, "tasks")
+	if ev := nextEvent(t, events); ev.Type != EventPut || ev.Key != "b" {
+		t.Errorf("first missed event = %+v, want the put of b", ev)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventDelete || ev.Key != "a" {
+		t.Errorf("second missed event = %+v, want the delete of a", ev)
+	}
+}
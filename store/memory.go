@@ -0,0 +1,71 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// in-memory store, everything is gone once the process exits
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		data: make(map[string]map[string][]byte),
+	}
+}
+
+func (m *Memory) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error in json marshal of %s/%s: %v", bucket, key, err)
+	}
+	m.put(bucket, key, data)
+	return nil
+}
+
+func (m *Memory) put(bucket string, key string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data[bucket] == nil {
+		m.data[bucket] = make(map[string][]byte)
+	}
+	m.data[bucket][key] = data
+}
+
+func (m *Memory) Get(bucket string, key string, value interface{}) error {
+	m.mu.RLock()
+	data, ok := m.data[bucket][key]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("error in json unmarshal of %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (m *Memory) Delete(bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data[bucket], key)
+	return nil
+}
+
+func (m *Memory) List(bucket string) (map[string][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	values := make(map[string][]byte, len(m.data[bucket]))
+	for k, v := range m.data[bucket] {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
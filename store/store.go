@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// store keeps the state of the cluster so that it survives restarts
+// values are grouped in buckets (tasks, events, ...) and are saved as json under a key
+type Store interface {
+	Put(bucket string, key string, value interface{}) error
+	Get(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	List(bucket string) (map[string][]byte, error)
+	Close() error
+}
+
+// stores which can tell about the changes made by other components sharing the same state
+type Watcher interface {
+	Watch(ctx context.Context, rev int64, buckets ...string) <-chan Event
+}
+
+const (
+	EventPut    = "put"
+	EventDelete = "delete"
+)
+
+// a change to a key, value is empty when the key was deleted
+type Event struct {
+	Type     string
+	Bucket   string
+	Key      string
+	Value    []byte
+	Revision int64
+}
+
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrConflict = errors.New("key was modified by someone else")
+	// the store was fenced and the fence doesn't hold anymore, see Etcd.Fence
+	ErrFenced = errors.New("write was fenced off")
+)
@@ -0,0 +1,269 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// the docker runtime, every call goes to the docker daemon
+// docker can only limit the disk of a container on some storage drivers (e.g. overlay2 on xfs with pquota)
+// so the disk of a task is only enforced when StorageQuota is set
+type Docker struct {
+	Client       *client.Client
+	StorageQuota bool
+}
+
+func (d *Docker) Pull(ctx context.Context, ref string) error {
+	reader, err := d.Client.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(os.Stdout, reader)
+	return err
+}
+
+func (d *Docker) Create(ctx context.Context, c Config) (string, error) {
+	r := container.Resources{
+		Memory:            c.Memory,
+		MemoryReservation: c.MemoryReservation,
+		MemorySwap:        c.MemorySwap,
+		NanoCPUs:          c.NanoCPUs,
+		CPUShares:         c.CPUShares,
+	}
+	if c.PidsLimit > 0 {
+		r.PidsLimit = &c.PidsLimit
+	}
+
+	// args only make sense after a command, so without one they replace the command of the image
+	var cmd []string
+	cmd = append(cmd, c.Cmd...)
+	cmd = append(cmd, c.Args...)
+
+	exposed := nat.PortSet{}
+	for p := range c.PortBindings {
+		exposed[p] = struct{}{}
+	}
+
+	cc := container.Config{
+		Image:        c.Image,
+		Entrypoint:   c.Entrypoint,
+		Cmd:          cmd,
+		WorkingDir:   c.WorkingDir,
+		Env:          c.Env,
+		Labels:       c.Labels,
+		ExposedPorts: exposed,
+	}
+
+	// docker picks a random host port for the bindings without one
+	// it never restarts the container itself, restarting is up to the manager
+	hc := container.HostConfig{
+		Resources:    r,
+		PortBindings: c.PortBindings,
+	}
+	if d.StorageQuota && c.Disk > 0 {
+		hc.StorageOpt = map[string]string{"size": strconv.FormatInt(c.Disk, 10)}
+	}
+	for _, m := range c.Mounts {
+		dm := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == MountTmpfs && m.Size > 0 {
+			dm.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: int64(m.Size)}
+		}
+		hc.Mounts = append(hc.Mounts, dm)
+	}
+
+	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, c.Name)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *Docker) Start(ctx context.Context, id string) error {
+	return d.Client.ContainerStart(ctx, id, container.StartOptions{})
+}
+
+func (d *Docker) Stop(ctx context.Context, id string) error {
+	return d.Client.ContainerStop(ctx, id, container.StopOptions{})
+}
+
+func (d *Docker) Remove(ctx context.Context, id string) error {
+	return d.Client.ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+}
+
+func (d *Docker) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	resp, err := d.Client.ContainerInspect(ctx, id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		ID:      resp.ID,
+		Name:    strings.TrimPrefix(resp.Name, "/"),
+		Created: parseTime(resp.Created),
+	}
+	if resp.Config != nil {
+		info.Image = resp.Config.Image
+		info.Command = append(append([]string{}, resp.Config.Entrypoint...), resp.Config.Cmd...)
+		info.Labels = resp.Config.Labels
+	}
+	if resp.State != nil {
+		info.Status = resp.State.Status
+		info.ExitCode = resp.State.ExitCode
+		info.OOMKilled = resp.State.OOMKilled
+		info.Error = resp.State.Error
+		info.StartedAt = parseTime(resp.State.StartedAt)
+		info.FinishedAt = parseTime(resp.State.FinishedAt)
+	}
+	if resp.NetworkSettings != nil {
+		info.Ports = resp.NetworkSettings.Ports
+	}
+	return info, nil
+}
+
+// docker reports the times as strings, a container which never started has the zero time
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// the logs of docker are multiplexed, so they are split into stdout and stderr again
+func (d *Docker) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	out, err := d.Client.ContainerLogs(ctx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, out)
+		out.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (d *Docker) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	resp, err := d.Client.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	defer resp.Body.Close()
+
+	var s container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return ContainerStats{}, fmt.Errorf("error in decoding the stats of %s: %v", id, err)
+	}
+	return ContainerStats{
+		CPUUsage:    s.CPUStats.CPUUsage.TotalUsage,
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+		Pids:        s.PidsStats.Current,
+	}, nil
+}
+
+func (d *Docker) List(ctx context.Context) ([]ContainerInfo, error) {
+	containers, err := d.Client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelTaskID)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		infos = append(infos, containerInfo(c))
+	}
+	return infos, nil
+}
+
+func containerInfo(c types.Container) ContainerInfo {
+	name := ""
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	return ContainerInfo{
+		ID:      c.ID,
+		Name:    name,
+		Image:   c.Image,
+		Status:  c.State,
+		Labels:  c.Labels,
+		Created: time.Unix(c.Created, 0).UTC(),
+	}
+}
+
+func (d *Docker) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	resp, err := d.Client.ContainerExecCreate(ctx, id, container.ExecOptions{Cmd: cmd, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	att, err := d.Client.ContainerExecAttach(ctx, resp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer att.Close()
+
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, att.Reader); err != nil {
+		return ExecResult{}, err
+	}
+	inspect, err := d.Client.ContainerExecInspect(ctx, resp.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return ExecResult{ExitCode: inspect.ExitCode, Output: out.String()}, nil
+}
+
+func (d *Docker) CreateVolume(ctx context.Context, name string, labels map[string]string) error {
+	_, err := d.Client.VolumeCreate(ctx, volume.CreateOptions{Name: name, Labels: labels})
+	return err
+}
+
+func (d *Docker) InspectVolume(ctx context.Context, name string) (VolumeInfo, error) {
+	v, err := d.Client.VolumeInspect(ctx, name)
+	if err != nil {
+		return VolumeInfo{}, err
+	}
+	return VolumeInfo{
+		Name:    v.Name,
+		Labels:  v.Labels,
+		Created: parseTime(v.CreatedAt),
+	}, nil
+}
+
+func (d *Docker) RemoveVolume(ctx context.Context, name string) error {
+	return d.Client.VolumeRemove(ctx, name, false)
+}
+
+func NewDocker() (*Docker, error) {
+	dc, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return &Docker{Client: dc}, nil
+}
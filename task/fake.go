@@ -0,0 +1,382 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// first host port handed out by the fake, the same as the start of the ephemeral range of docker
+const fakeFirstPort = 32768
+
+// a runtime which only pretends to run containers, everything is kept in memory
+// containers keep running until they are stopped or Exit is called
+// ports get the host port they are bound to, or increasing host ports when they have none
+// PullErrors makes pulling the given images fail
+// like docker a volume which is mounted but doesn't exist is created when the container is
+type Fake struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	images     map[string]bool
+	volumes    map[string]VolumeInfo
+	nextPort   int
+	PullErrors map[string]error
+}
+
+type fakeContainer struct {
+	info     ContainerInfo
+	mounts   []Mount
+	bindings nat.PortMap
+	logs     strings.Builder
+	stats    ContainerStats
+	exec     ExecResult
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		containers: make(map[string]*fakeContainer),
+		images:     make(map[string]bool),
+		volumes:    make(map[string]VolumeInfo),
+		nextPort:   fakeFirstPort,
+		PullErrors: make(map[string]error),
+	}
+}
+
+func (f *Fake) Pull(ctx context.Context, image string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.PullErrors[image]; err != nil {
+		return err
+	}
+	f.images[image] = true
+	return nil
+}
+
+func (f *Fake) Create(ctx context.Context, c Config) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.images[c.Image] {
+		return "", fmt.Errorf("no such image: %s", c.Image)
+	}
+	for _, fc := range f.containers {
+		if c.Name != "" && fc.info.Name == c.Name {
+			return "", fmt.Errorf("the container name %s is already in use", c.Name)
+		}
+	}
+
+	for _, m := range c.Mounts {
+		if _, ok := f.volumes[m.Source]; m.Type == MountVolume && !ok {
+			f.volumes[m.Source] = VolumeInfo{Name: m.Source, Created: time.Now().UTC()}
+		}
+	}
+
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	ports := nat.PortMap{}
+	for p := range c.PortBindings {
+		ports[p] = nil
+	}
+	f.containers[id] = &fakeContainer{
+		info: ContainerInfo{
+			ID:      id,
+			Name:    c.Name,
+			Image:   c.Image,
+			Command: c.Command(),
+			Status:  "created",
+			Labels:  c.Labels,
+			Ports:   ports,
+			Created: time.Now().UTC(),
+		},
+		mounts:   c.Mounts,
+		bindings: c.PortBindings,
+		stats:    ContainerStats{MemoryLimit: uint64(c.Memory)},
+	}
+	return id, nil
+}
+
+func (f *Fake) container(id string) (*fakeContainer, error) {
+	fc, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	return fc, nil
+}
+
+func (f *Fake) Start(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	if fc.info.Status == "running" {
+		return nil
+	}
+
+	// like docker a restarted container keeps its ports
+	for p, b := range fc.info.Ports {
+		if len(b) > 0 {
+			continue
+		}
+		for _, want := range fc.bindings[p] {
+			if want.HostPort == "" {
+				continue
+			}
+			if f.allocated(p.Proto(), want.HostPort) {
+				return fmt.Errorf("bind for %s:%s failed: port is already allocated", want.HostIP, want.HostPort)
+			}
+			ip := want.HostIP
+			if ip == "" {
+				ip = "0.0.0.0"
+			}
+			fc.info.Ports[p] = append(fc.info.Ports[p], nat.PortBinding{HostIP: ip, HostPort: want.HostPort})
+		}
+		if len(fc.info.Ports[p]) == 0 {
+			fc.info.Ports[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: strconv.Itoa(f.nextPort)}}
+			f.nextPort++
+		}
+	}
+	fc.info.Status = "running"
+	fc.info.ExitCode = 0
+	fc.info.StartedAt = time.Now().UTC()
+	fc.info.FinishedAt = time.Time{}
+	fc.stats.Pids = 1
+	return nil
+}
+
+// whether a running container is bound to the host port already
+func (f *Fake) allocated(proto string, hostPort string) bool {
+	for _, fc := range f.containers {
+		if fc.info.Status != "running" {
+			continue
+		}
+		for p, bs := range fc.info.Ports {
+			for _, b := range bs {
+				if p.Proto() == proto && b.HostPort == hostPort {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (f *Fake) Stop(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	if fc.info.Status == "running" {
+		fc.exit(0)
+	}
+	return nil
+}
+
+// a copy of the info, the ports change when the container is started
+func (fc *fakeContainer) snapshot() ContainerInfo {
+	info := fc.info
+	info.Ports = nat.PortMap{}
+	for p, b := range fc.info.Ports {
+		info.Ports[p] = append([]nat.PortBinding(nil), b...)
+	}
+	return info
+}
+
+func (fc *fakeContainer) exit(code int) {
+	fc.info.Status = "exited"
+	fc.info.ExitCode = code
+	fc.info.FinishedAt = time.Now().UTC()
+	fc.stats.Pids = 0
+	fc.stats.MemoryUsage = 0
+}
+
+func (f *Fake) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.container(id); err != nil {
+		return err
+	}
+	delete(f.containers, id)
+	return nil
+}
+
+func (f *Fake) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	return fc.snapshot(), nil
+}
+
+func (f *Fake) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(fc.logs.String())), nil
+}
+
+func (f *Fake) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	return fc.stats, nil
+}
+
+func (f *Fake) List(ctx context.Context) ([]ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	infos := make([]ContainerInfo, 0, len(f.containers))
+	for _, fc := range f.containers {
+		if _, ok := fc.info.Labels[LabelTaskID]; ok {
+			infos = append(infos, fc.snapshot())
+		}
+	}
+	return infos, nil
+}
+
+// every command exits with what SetExec was given, 0 by default
+func (f *Fake) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ExecResult{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	if fc.info.Status != "running" {
+		return ExecResult{}, fmt.Errorf("container %s is not running", id)
+	}
+	return fc.exec, nil
+}
+
+// sets how the commands run in the container by Exec go
+func (f *Fake) SetExec(id string, r ExecResult) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	fc.exec = r
+	return nil
+}
+
+func (f *Fake) CreateVolume(ctx context.Context, name string, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.volumes[name]; !ok {
+		f.volumes[name] = VolumeInfo{Name: name, Labels: labels, Created: time.Now().UTC()}
+	}
+	return nil
+}
+
+func (f *Fake) InspectVolume(ctx context.Context, name string) (VolumeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.volumes[name]
+	if !ok {
+		return VolumeInfo{}, fmt.Errorf("no such volume: %s", name)
+	}
+	return v, nil
+}
+
+// a volume can't be removed while a container still mounts it
+func (f *Fake) RemoveVolume(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.volumes[name]; !ok {
+		return fmt.Errorf("no such volume: %s", name)
+	}
+	for id, fc := range f.containers {
+		for _, m := range fc.mounts {
+			if m.Type == MountVolume && m.Source == name {
+				return fmt.Errorf("volume %s is in use by container %s", name, id)
+			}
+		}
+	}
+	delete(f.volumes, name)
+	return nil
+}
+
+// the process of the container exits with the given code, as if it crashed or finished on its own
+func (f *Fake) Exit(id string, code int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	if fc.info.Status != "running" {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	fc.exit(code)
+	return nil
+}
+
+// the container is killed because it ran out of memory
+func (f *Fake) OOMKill(id string) error {
+	if err := f.Exit(id, 137); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers[id].info.OOMKilled = true
+	return nil
+}
+
+// appends a line to the logs of the container
+func (f *Fake) Log(id string, line string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	fc.logs.WriteString(line + "\n")
+	return nil
+}
+
+// sets what Stats reports for the container
+func (f *Fake) SetStats(id string, s ContainerStats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	fc.stats = s
+	return nil
+}
@@ -0,0 +1,136 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// the kinds of health checks a task can have
+const (
+	HealthHTTP = "HTTP"
+	HealthTCP  = "TCP"
+	HealthExec = "Exec"
+	HealthGRPC = "GRPC"
+)
+
+// the health of a running task as seen by the checks of its worker
+// a task is starting until its check passed or failed often enough in a row
+const (
+	HealthStarting  = "Starting"
+	HealthHealthy   = "Healthy"
+	HealthUnhealthy = "Unhealthy"
+)
+
+// how the worker checks whether a task is healthy
+// http asks for path with method (GET by default) and headers and expects status (any 2xx or 3xx when it is zero),
+// tcp only connects, exec runs command inside the container and expects it to exit with 0
+// and grpc asks the grpc health service for service (the whole server when it is empty)
+// port is the container port the check goes to, e.g. "8080/tcp", the first published tcp port when it is empty
+// the first check runs initial delay seconds after the start and then every interval seconds, each one gets timeout seconds
+// the task becomes unhealthy after failure threshold failed checks in a row and healthy again after success threshold passed ones
+type HealthCheck struct {
+	Type                string
+	Port                string
+	Path                string
+	Method              string
+	Headers             map[string]string
+	Status              int
+	Command             []string
+	Service             string
+	TimeoutSeconds      int
+	IntervalSeconds     int
+	InitialDelaySeconds int
+	SuccessThreshold    int
+	FailureThreshold    int
+}
+
+// a health check used to be the path of an http check on the first port, which is still accepted
+func (h *HealthCheck) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*h = HealthCheck{}
+		if path != "" {
+			h.Type = HealthHTTP
+			h.Path = path
+		}
+		return nil
+	}
+	type spec HealthCheck
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode((*spec)(h))
+}
+
+// whether there is anything to check, an empty health check is the same as none
+func (h *HealthCheck) Active() bool {
+	return h != nil && h.Type != ""
+}
+
+// the health check with the defaults filled in, the same ones as kubernetes uses apart from the interval
+func (h HealthCheck) Defaults() HealthCheck {
+	if h.Type == HealthHTTP && h.Method == "" {
+		h.Method = "GET"
+	}
+	if h.Type == HealthHTTP && h.Path == "" {
+		h.Path = "/"
+	}
+	if h.TimeoutSeconds == 0 {
+		h.TimeoutSeconds = 1
+	}
+	if h.IntervalSeconds == 0 {
+		h.IntervalSeconds = 10
+	}
+	if h.SuccessThreshold == 0 {
+		h.SuccessThreshold = 1
+	}
+	if h.FailureThreshold == 0 {
+		h.FailureThreshold = 3
+	}
+	return h
+}
+
+// the container port the check goes to, nil when it should use the first published one
+func (h HealthCheck) ContainerPort() (*nat.Port, error) {
+	if h.Port == "" {
+		return nil, nil
+	}
+	proto, port := nat.SplitProtoPort(h.Port)
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid health check port %s", h.Port)
+	}
+	p, err := nat.NewPort(proto, port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check port %s: %v", h.Port, err)
+	}
+	return &p, nil
+}
+
+func (h HealthCheck) Validate() error {
+	switch h.Type {
+	case HealthHTTP:
+		if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+			return fmt.Errorf("the path of an http health check has to start with /, got %q", h.Path)
+		}
+		if h.Status != 0 && (h.Status < 100 || h.Status > 599) {
+			return fmt.Errorf("invalid http status %d for the health check", h.Status)
+		}
+	case HealthTCP, HealthGRPC:
+	case HealthExec:
+		if len(h.Command) == 0 {
+			return errors.New("an exec health check needs a command")
+		}
+	default:
+		return fmt.Errorf("unknown health check type %q, it has to be %s, %s, %s or %s", h.Type, HealthHTTP, HealthTCP, HealthExec, HealthGRPC)
+	}
+	if h.TimeoutSeconds < 0 || h.IntervalSeconds < 0 || h.InitialDelaySeconds < 0 || h.SuccessThreshold < 0 || h.FailureThreshold < 0 {
+		return errors.New("the timeout, interval, initial delay and thresholds of a health check can't be negative")
+	}
+	_, err := h.ContainerPort()
+	return err
+}
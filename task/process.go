@@ -0,0 +1,596 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// the type of the cgroup v2 filesystem as reported by statfs
+const cgroup2Magic = 0x63677270
+
+// how long a process gets to exit after SIGTERM before it is killed
+const processStopTimeout = 10 * time.Second
+
+// a runtime which runs the task as a plain process on the host, for hosts without docker
+// every process gets a directory below Dir with its state and the stdout.log and stderr.log files
+// when CgroupRoot is set (e.g. /sys/fs/cgroup/core) every process is put in its own cgroup v2 with the memory, cpu and pids limits of the task
+// the disk of a task can't be limited for a process and nothing can be mounted for it, it shares the filesystem of the host
+// the state is written to disk, so that a restarted worker still knows the processes it started
+type Process struct {
+	mu         sync.Mutex
+	Dir        string
+	CgroupRoot string
+	procs      map[string]*process
+}
+
+type process struct {
+	Config Config
+	Info   ContainerInfo
+	Pid    int
+	// closed once the process has exited
+	done chan struct{}
+}
+
+func NewProcess(dir string, cgroupRoot string) (*Process, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if cgroupRoot != "" {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(filepath.Dir(cgroupRoot), &fs); err != nil {
+			return nil, err
+		}
+		if fs.Type != cgroup2Magic {
+			return nil, fmt.Errorf("%s is not on a cgroup v2 filesystem", cgroupRoot)
+		}
+		if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+			return nil, fmt.Errorf("error in creating the cgroup %s: %v", cgroupRoot, err)
+		}
+		// the controllers have to be enabled for the cgroups of the processes
+		if err := os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0644); err != nil {
+			return nil, fmt.Errorf("error in enabling the cgroup controllers in %s: %v", cgroupRoot, err)
+		}
+	}
+
+	p := &Process{
+		Dir:        dir,
+		CgroupRoot: cgroupRoot,
+		procs:      make(map[string]*process),
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reads the state of the processes started before a restart
+// processes which are still alive can't be waited for anymore, so they are polled until they exit
+func (p *Process) load() error {
+	entries, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(p.Dir, e.Name(), "state.json"))
+		if err != nil {
+			log.Printf("Error in reading the state of process %s: %v\n", e.Name(), err)
+			continue
+		}
+		pr := &process{done: make(chan struct{})}
+		if err := json.Unmarshal(data, pr); err != nil {
+			log.Printf("Error in decoding the state of process %s: %v\n", e.Name(), err)
+			continue
+		}
+		p.procs[pr.Info.ID] = pr
+
+		if pr.Info.Status != "running" {
+			close(pr.done)
+			continue
+		}
+		if !alive(pr.Pid) {
+			pr.Info.Status = "exited"
+			pr.Info.ExitCode = -1
+			pr.Info.Error = "the process exited while the worker was down"
+			pr.Info.FinishedAt = time.Now().UTC()
+			close(pr.done)
+			p.save(pr)
+			continue
+		}
+		go p.poll(pr)
+	}
+	return nil
+}
+
+func alive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+func (p *Process) poll(pr *process) {
+	for alive(pr.Pid) {
+		time.Sleep(time.Second)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr.Info.Status = "exited"
+	// the process isn't our child anymore, so its exit code is lost
+	pr.Info.ExitCode = -1
+	pr.Info.FinishedAt = time.Now().UTC()
+	pr.Info.OOMKilled = p.oomKilled(pr.Info.ID)
+	close(pr.done)
+	p.save(pr)
+}
+
+func (p *Process) dir(id string) string {
+	return filepath.Join(p.Dir, id)
+}
+
+func (p *Process) cgroup(id string) string {
+	return filepath.Join(p.CgroupRoot, id)
+}
+
+// has to be called with mu held
+func (p *Process) save(pr *process) {
+	data, err := json.Marshal(pr)
+	if err != nil {
+		log.Printf("Error in encoding the state of process %s: %v\n", pr.Info.ID, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(p.dir(pr.Info.ID), "state.json"), data, 0644); err != nil {
+		log.Printf("Error in saving the state of process %s: %v\n", pr.Info.ID, err)
+	}
+}
+
+func (p *Process) get(id string) (*process, error) {
+	pr, ok := p.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+	return pr, nil
+}
+
+// there is no image to pull for a process
+func (p *Process) Pull(ctx context.Context, image string) error {
+	return ctx.Err()
+}
+
+func (p *Process) Create(ctx context.Context, c Config) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	command := c.Command()
+	if len(command) == 0 {
+		return "", errors.New("the process runtime needs a command to run")
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return "", err
+	}
+	if len(c.Mounts) > 0 {
+		return "", errors.New("the process runtime can't mount storage")
+	}
+
+	// a process listens on the host directly, so every port is its own host port and can't be mapped to another one
+	ports := nat.PortMap{}
+	for port, bs := range c.PortBindings {
+		ip := "0.0.0.0"
+		for _, b := range bs {
+			if b.HostPort != "" && b.HostPort != port.Port() {
+				return "", fmt.Errorf("the process runtime can't publish port %s on host port %s", port, b.HostPort)
+			}
+			if b.HostIP != "" {
+				ip = b.HostIP
+			}
+		}
+		ports[port] = []nat.PortBinding{{HostIP: ip, HostPort: port.Port()}}
+	}
+
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	if err := os.MkdirAll(p.dir(id), 0755); err != nil {
+		return "", err
+	}
+
+	pr := &process{
+		Config: c,
+		Info: ContainerInfo{
+			ID:      id,
+			Name:    c.Name,
+			Image:   c.Image,
+			Command: command,
+			Status:  "created",
+			Labels:  c.Labels,
+			Ports:   ports,
+			Created: time.Now().UTC(),
+		},
+		done: make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.procs[id] = pr
+	p.save(pr)
+	return id, nil
+}
+
+// creates the cgroup of the process and writes its limits, zero means unlimited
+func (p *Process) createCgroup(id string, c Config) (*os.File, error) {
+	dir := p.cgroup(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	limits := make(map[string]string)
+	if c.Memory > 0 {
+		limits["memory.max"] = strconv.FormatInt(c.Memory, 10)
+	}
+	if c.MemoryReservation > 0 {
+		limits["memory.low"] = strconv.FormatInt(c.MemoryReservation, 10)
+	}
+	// docker counts memory and swap together while cgroups only count the swap
+	switch {
+	case c.MemorySwap == -1:
+		limits["memory.swap.max"] = "max"
+	case c.MemorySwap > 0:
+		limits["memory.swap.max"] = strconv.FormatInt(c.MemorySwap-c.Memory, 10)
+	}
+	if c.NanoCPUs > 0 {
+		// the quota is per period of 100ms
+		period := int64(100000)
+		quota := c.NanoCPUs * period / 1e9
+		limits["cpu.max"] = fmt.Sprintf("%d %d", quota, period)
+	}
+	if c.CPUShares > 0 {
+		// shares go from 2 to 262144 with 1024 as the default, the weight from 1 to 10000 with 100 as the default
+		weight := 1 + (c.CPUShares-2)*9999/262142
+		limits["cpu.weight"] = strconv.FormatInt(min(max(weight, 1), 10000), 10)
+	}
+	if c.PidsLimit > 0 {
+		limits["pids.max"] = strconv.FormatInt(c.PidsLimit, 10)
+	}
+	for file, limit := range limits {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(limit), 0644); err != nil {
+			return nil, fmt.Errorf("error in writing %s: %v", file, err)
+		}
+	}
+	return os.Open(dir)
+}
+
+func (p *Process) Start(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, err := p.get(id)
+	if err != nil {
+		return err
+	}
+	if pr.Info.Status == "running" {
+		return nil
+	}
+	c := pr.Config
+
+	stdout, err := os.Create(filepath.Join(p.dir(id), "stdout.log"))
+	if err != nil {
+		return err
+	}
+	stderr, err := os.Create(filepath.Join(p.dir(id), "stderr.log"))
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+
+	// not bound to ctx, the process has to outlive the start
+	command := c.Command()
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Dir = c.WorkingDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// its own process group, so that stopping it stops its children as well
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if p.CgroupRoot != "" {
+		cg, err := p.createCgroup(id, c)
+		if err != nil {
+			stdout.Close()
+			stderr.Close()
+			return fmt.Errorf("error in creating the cgroup of %s: %v", id, err)
+		}
+		defer cg.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.Fd())
+	}
+
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		return err
+	}
+
+	pr.Pid = cmd.Process.Pid
+	pr.Info.Status = "running"
+	pr.Info.ExitCode = 0
+	pr.Info.Error = ""
+	pr.Info.StartedAt = time.Now().UTC()
+	pr.Info.FinishedAt = time.Time{}
+	pr.done = make(chan struct{})
+	p.save(pr)
+
+	go p.wait(pr, cmd, stdout, stderr)
+	return nil
+}
+
+func (p *Process) wait(pr *process, cmd *exec.Cmd, stdout *os.File, stderr *os.File) {
+	err := cmd.Wait()
+	stdout.Close()
+	stderr.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr.Info.Status = "exited"
+	pr.Info.ExitCode = cmd.ProcessState.ExitCode()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		pr.Info.Error = err.Error()
+	}
+	// killed by a signal, report it the way a shell does
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		pr.Info.ExitCode = 128 + int(ws.Signal())
+	}
+	pr.Info.FinishedAt = time.Now().UTC()
+	pr.Info.OOMKilled = p.oomKilled(pr.Info.ID)
+	close(pr.done)
+	p.save(pr)
+}
+
+// the kernel counts the processes it killed in the cgroup because it ran out of memory
+func (p *Process) oomKilled(id string) bool {
+	if p.CgroupRoot == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(p.cgroup(id), "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == "oom_kill" && f[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// sends SIGTERM to the process group and SIGKILL if it hasn't exited after processStopTimeout
+func (p *Process) Stop(ctx context.Context, id string) error {
+	p.mu.Lock()
+	pr, err := p.get(id)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if pr.Info.Status != "running" {
+		p.mu.Unlock()
+		return nil
+	}
+	pid := pr.Pid
+	done := pr.done
+	p.mu.Unlock()
+
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	timer := time.NewTimer(processStopTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	log.Printf("Process %s did not exit in time, killing it\n", id)
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// kills the process if it is still running and removes its logs and cgroup
+func (p *Process) Remove(ctx context.Context, id string) error {
+	p.mu.Lock()
+	pr, err := p.get(id)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if pr.Info.Status == "running" {
+		syscall.Kill(-pr.Pid, syscall.SIGKILL)
+		done := pr.done
+		p.mu.Unlock()
+		<-done
+		p.mu.Lock()
+	}
+	defer p.mu.Unlock()
+	delete(p.procs, id)
+
+	if p.CgroupRoot != "" {
+		if err := os.Remove(p.cgroup(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error in removing the cgroup of process %s: %v\n", id, err)
+		}
+	}
+	return os.RemoveAll(p.dir(id))
+}
+
+func (p *Process) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, err := p.get(id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	return pr.Info, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	files []*os.File
+}
+
+func (m *multiReadCloser) Close() error {
+	for _, f := range m.files {
+		f.Close()
+	}
+	return nil
+}
+
+// stdout followed by stderr
+func (p *Process) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	p.mu.Lock()
+	_, err := p.get(id)
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := os.Open(filepath.Join(p.dir(id), "stdout.log"))
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := os.Open(filepath.Join(p.dir(id), "stderr.log"))
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	return &multiReadCloser{Reader: io.MultiReader(stdout, stderr), files: []*os.File{stdout, stderr}}, nil
+}
+
+// reads the usage from the cgroup of the process, without cgroups only the process itself is counted
+func (p *Process) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	p.mu.Lock()
+	pr, err := p.get(id)
+	if err != nil {
+		p.mu.Unlock()
+		return ContainerStats{}, err
+	}
+	pid := pr.Pid
+	running := pr.Info.Status == "running"
+	limit := uint64(max(pr.Config.Memory, 0))
+	p.mu.Unlock()
+
+	s := ContainerStats{MemoryLimit: limit}
+	if !running {
+		return s, nil
+	}
+
+	if p.CgroupRoot != "" {
+		dir := p.cgroup(id)
+		s.MemoryUsage = readUint(filepath.Join(dir, "memory.current"))
+		s.Pids = readUint(filepath.Join(dir, "pids.current"))
+		data, err := os.ReadFile(filepath.Join(dir, "cpu.stat"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				f := strings.Fields(line)
+				if len(f) == 2 && f[0] == "usage_usec" {
+					usec, _ := strconv.ParseUint(f[1], 10, 64)
+					s.CPUUsage = usec * 1000
+				}
+			}
+		}
+		return s, nil
+	}
+
+	stat, err := linux.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return s, err
+	}
+	// the times are in clock ticks, which are 100 per second on linux
+	s.CPUUsage = (stat.Utime + stat.Stime) * uint64(10*time.Millisecond)
+	s.MemoryUsage = uint64(max(stat.Rss, 0)) * uint64(os.Getpagesize())
+	s.Pids = 1
+	return s, nil
+}
+
+func readUint(path string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+func (p *Process) List(ctx context.Context) ([]ContainerInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	infos := make([]ContainerInfo, 0, len(p.procs))
+	for _, pr := range p.procs {
+		if _, ok := pr.Info.Labels[LabelTaskID]; ok {
+			infos = append(infos, pr.Info)
+		}
+	}
+	return infos, nil
+}
+
+// there is no container, so the command runs on the host with the environment and working dir of the process
+func (p *Process) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	p.mu.Lock()
+	pr, err := p.get(id)
+	if err != nil {
+		p.mu.Unlock()
+		return ExecResult{}, err
+	}
+	running := pr.Info.Status == "running"
+	c := pr.Config
+	p.mu.Unlock()
+	if !running {
+		return ExecResult{}, fmt.Errorf("process %s is not running", id)
+	}
+	if len(cmd) == 0 {
+		return ExecResult{}, errors.New("no command to run")
+	}
+
+	ec := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	ec.Env = append(os.Environ(), c.Env...)
+	ec.Dir = c.WorkingDir
+	out, err := ec.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ExecResult{ExitCode: exitErr.ExitCode(), Output: string(out)}, nil
+	}
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return ExecResult{Output: string(out)}, nil
+}
+
+var errNoVolumes = errors.New("the process runtime has no volumes")
+
+func (p *Process) CreateVolume(ctx context.Context, name string, labels map[string]string) error {
+	return errNoVolumes
+}
+
+func (p *Process) InspectVolume(ctx context.Context, name string) (VolumeInfo, error) {
+	return VolumeInfo{}, errNoVolumes
+}
+
+func (p *Process) RemoveVolume(ctx context.Context, name string) error {
+	return errNoVolumes
+}
@@ -0,0 +1,251 @@
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestProcess(t *testing.T, dir string) *Process {
+	t.Helper()
+	p, err := NewProcess(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func startProcess(t *testing.T, p *Process, command ...string) string {
+	t.Helper()
+	ctx := context.Background()
+	id, err := p.Create(ctx, Config{Name: "test", Cmd: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Remove(context.Background(), id) })
+	return id
+}
+
+// inspects the process until it has exited
+func waitExited(t *testing.T, p *Process, id string) ContainerInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := p.Inspect(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Status == "exited" {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process %s is still %s", id, info.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessExitCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		want    int
+	}{
+		{"success", []string{"true"}, 0},
+		{"failure", []string{"sh", "-c", "exit 3"}, 3},
+		{"killed by a signal", []string{"sh", "-c", "kill -KILL $$"}, 128 + int(syscall.SIGKILL)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProcess(t, t.TempDir())
+			id, err := p.Create(context.Background(), Config{Name: "test", Cmd: tt.command})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info, _ := p.Inspect(context.Background(), id); info.Status != "created" {
+				t.Fatalf("created process is %s", info.Status)
+			}
+			if err := p.Start(context.Background(), id); err != nil {
+				t.Fatal(err)
+			}
+			info := waitExited(t, p, id)
+			if info.ExitCode != tt.want || info.Error != "" {
+				t.Errorf("exited with %d (%q), want %d", info.ExitCode, info.Error, tt.want)
+			}
+			if info.StartedAt.IsZero() || info.FinishedAt.Before(info.StartedAt) {
+				t.Errorf("started at %v and finished at %v", info.StartedAt, info.FinishedAt)
+			}
+		})
+	}
+}
+
+func TestProcessCreate(t *testing.T) {
+	p := newTestProcess(t, t.TempDir())
+	tests := []struct {
+		name string
+		c    Config
+	}{
+		{"no command", Config{Name: "test"}},
+		{"unknown command", Config{Name: "test", Cmd: []string{"no-such-command-here"}}},
+		{"mounts", Config{Name: "test", Cmd: []string{"true"}, Mounts: []Mount{{Type: MountTmpfs, Target: "/tmp"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := p.Create(context.Background(), tt.c); err == nil {
+			t.Errorf("%s: process created", tt.name)
+		}
+	}
+}
+
+func TestProcessStopKills(t *testing.T) {
+	p := newTestProcess(t, t.TempDir())
+	// ignores SIGTERM, along with its children
+	id := startProcess(t, p, "sh", "-c", "trap '' TERM; sleep 30")
+	time.Sleep(100 * time.Millisecond)
+
+	// the context runs out long before processStopTimeout, so the process is killed right away
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.Stop(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("stop took %v", took)
+	}
+	info, _ := p.Inspect(context.Background(), id)
+	if info.Status != "exited" || info.ExitCode != 128+int(syscall.SIGKILL) {
+		t.Errorf("stopped process is %s with %d, want it killed", info.Status, info.ExitCode)
+	}
+}
+
+func TestProcessStopTerminates(t *testing.T) {
+	p := newTestProcess(t, t.TempDir())
+	id := startProcess(t, p, "sleep", "30")
+	if err := p.Stop(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := p.Inspect(context.Background(), id)
+	if info.Status != "exited" || info.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Errorf("stopped process is %s with %d, want it terminated", info.Status, info.ExitCode)
+	}
+}
+
+func TestProcessAdoptedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestProcess(t, dir)
+	running := startProcess(t, p, "sleep", "30")
+	exited := startProcess(t, p, "sh", "-c", "exit 2")
+	waitExited(t, p, exited)
+
+	// the worker restarts, the new runtime finds the processes in its dir
+	again := newTestProcess(t, dir)
+	info, err := again.Inspect(context.Background(), running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != "running" {
+		t.Fatalf("adopted process is %s", info.Status)
+	}
+	if info, _ := again.Inspect(context.Background(), exited); info.Status != "exited" || info.ExitCode != 2 {
+		t.Errorf("process which exited before the restart is %s with %d", info.Status, info.ExitCode)
+	}
+
+	// it isn't the parent anymore, so it only notices the exit without its code
+	p.Stop(context.Background(), running)
+	info = waitExited(t, again, running)
+	if info.ExitCode != -1 || info.FinishedAt.IsZero() {
+		t.Errorf("adopted process exited with %d at %v", info.ExitCode, info.FinishedAt)
+	}
+}
+
+func TestProcessGoneWhileDown(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestProcess(t, dir)
+	id := startProcess(t, p, "sleep", "30")
+	data, err := os.ReadFile(filepath.Join(dir, id, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Stop(context.Background(), id)
+	// the exit is saved under the lock, so once it can be inspected it won't overwrite the state below
+	waitExited(t, p, id)
+	// the state the worker saw last, before it went down
+	if err := os.WriteFile(filepath.Join(dir, id, "state.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := newTestProcess(t, dir).Inspect(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != "exited" || info.ExitCode != -1 || info.Error == "" {
+		t.Errorf("process which exited while the worker was down is %s with %d (%q)", info.Status, info.ExitCode, info.Error)
+	}
+}
+
+func TestProcessNeedsCgroup2(t *testing.T) {
+	if _, err := NewProcess(t.TempDir(), filepath.Join(t.TempDir(), "core")); err == nil {
+		t.Error("cgroup root outside of a cgroup v2 filesystem accepted")
+	}
+}
+
+func TestProcessCgroupLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Config
+		want map[string]string
+	}{
+		{"no limits", Config{}, map[string]string{}},
+		{"memory", Config{Memory: 256 << 20, MemoryReservation: 128 << 20}, map[string]string{
+			"memory.max": "268435456", "memory.low": "134217728",
+		}},
+		// docker counts memory and swap together, the cgroup only the swap
+		{"swap", Config{Memory: 256 << 20, MemorySwap: 512 << 20}, map[string]string{
+			"memory.max": "268435456", "memory.swap.max": "268435456",
+		}},
+		{"unlimited swap", Config{Memory: 256 << 20, MemorySwap: -1}, map[string]string{
+			"memory.max": "268435456", "memory.swap.max": "max",
+		}},
+		{"cpu", Config{NanoCPUs: 1500000000, PidsLimit: 100}, map[string]string{
+			"cpu.max": "150000 100000", "pids.max": "100",
+		}},
+		{"default shares", Config{CPUShares: 1024}, map[string]string{"cpu.weight": "39"}},
+		{"fewest shares", Config{CPUShares: 2}, map[string]string{"cpu.weight": "1"}},
+		{"most shares", Config{CPUShares: 262144}, map[string]string{"cpu.weight": "10000"}},
+		{"more than the most shares", Config{CPUShares: 1 << 20}, map[string]string{"cpu.weight": "10000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Process{Dir: t.TempDir(), CgroupRoot: t.TempDir(), procs: make(map[string]*process)}
+			f, err := p.createCgroup("abc", tt.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			entries, err := os.ReadDir(p.cgroup("abc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("%d files written, want %d", len(entries), len(tt.want))
+			}
+			for file, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(p.cgroup("abc"), file))
+				if err != nil {
+					t.Errorf("%s: %v", file, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", file, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package task
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// a runtime is what actually runs the containers of the tasks on a worker
+// docker is the real one, the fake keeps everything in memory so that the stack can run without a docker daemon
+type Runtime interface {
+	Pull(ctx context.Context, image string) error
+	// creates the container without starting it and returns its id
+	Create(ctx context.Context, c Config) (string, error)
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string) error
+	Remove(ctx context.Context, id string) error
+	Inspect(ctx context.Context, id string) (ContainerInfo, error)
+	// the stdout and stderr of the container
+	Logs(ctx context.Context, id string) (io.ReadCloser, error)
+	Stats(ctx context.Context, id string) (ContainerStats, error)
+	// all the containers (running or not) which were created for a task
+	List(ctx context.Context) ([]ContainerInfo, error)
+	// creating a volume which exists already leaves it as it is
+	CreateVolume(ctx context.Context, name string, labels map[string]string) error
+	InspectVolume(ctx context.Context, name string) (VolumeInfo, error)
+	RemoveVolume(ctx context.Context, name string) error
+	// runs the command inside the running container
+	Exec(ctx context.Context, id string, cmd []string) (ExecResult, error)
+}
+
+// how a command run inside a container went, output is its stdout and stderr together
+type ExecResult struct {
+	ExitCode int
+	Output   string
+}
+
+// a named volume of a runtime
+type VolumeInfo struct {
+	Name    string
+	Labels  map[string]string
+	Created time.Time
+}
+
+// what a runtime knows about one of its containers
+// status is one of created, running or exited
+type ContainerInfo struct {
+	ID         string
+	Name       string
+	Image      string
+	Command    []string
+	Status     string
+	ExitCode   int
+	OOMKilled  bool
+	Error      string
+	Labels     map[string]string
+	Ports      nat.PortMap
+	Created    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// the resource usage of a container, cpu is the total cpu time in nanoseconds and memory is in bytes
+type ContainerStats struct {
+	CPUUsage    uint64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	Pids        uint64
+}
+
+// this will be used as a result after the task is assigned to analyze whether the docker container of executed sucessfully or not
+type DockerResult struct {
+	Error       error
+	Action      string
+	ContainerID string
+	Result      string
+}
+
+// This is similiar to docker run once the image is there
+func StartContainer(ctx context.Context, rt Runtime, c Config) DockerResult {
+	id, err := rt.Create(ctx, c)
+	if err != nil {
+		log.Printf("Error in creating container %v: %v", c, err)
+		return DockerResult{Error: err}
+	}
+
+	if err := rt.Start(ctx, id); err != nil {
+		log.Printf("Error in starting the container %v: %v", c, err)
+		// this has to work even when ctx was cancelled
+		if err := rt.Remove(context.Background(), id); err != nil {
+			log.Printf("Error in removing container %v: %v", c, err)
+		}
+		return DockerResult{Error: err}
+	}
+
+	return DockerResult{
+		Error:       nil,
+		Action:      "start",
+		ContainerID: id,
+		Result:      "success",
+	}
+}
+
+// This is similiar to docker stop followed by docker rm
+func StopContainer(ctx context.Context, rt Runtime, id string) DockerResult {
+	log.Printf("Attempting to stop container: %s", id)
+	if err := rt.Stop(ctx, id); err != nil {
+		log.Printf("Error in stopping container %v: %v", id, err)
+		return DockerResult{Error: err}
+	}
+
+	if err := rt.Remove(ctx, id); err != nil {
+		log.Printf("Error in removing container %v: %v", id, err)
+		return DockerResult{Error: err}
+	}
+
+	return DockerResult{
+		Error:       nil,
+		Action:      "stop",
+		ContainerID: id,
+		Result:      "success",
+	}
+}
@@ -1,17 +1,16 @@
 package task
 
 import (
-	"context"
-	"io"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
 )
@@ -19,20 +18,62 @@ import (
 type State int
 
 // iota represents the diff stages of the task
+// the states are stored as numbers, so new ones are only ever added at the end
 const (
 	Pending State = iota
 	Scheduled
 	Running
 	Completed
 	Failed
+	// the worker running the task is gone, so the task has to be scheduled again
+	Lost
+	// the worker is pulling the image of the task
+	Pulling
+	// the worker is creating and starting the container of the task
+	Starting
+	// the task was asked to stop and the worker is stopping its container
+	Stopping
+	// the task is being started again on its worker
+	Restarting
+	// the worker of the task stopped reporting, it may still be running
+	Unknown
 )
 
+var stateNames = map[State]string{
+	Pending:    "Pending",
+	Scheduled:  "Scheduled",
+	Running:    "Running",
+	Completed:  "Completed",
+	Failed:     "Failed",
+	Lost:       "Lost",
+	Pulling:    "Pulling",
+	Starting:   "Starting",
+	Stopping:   "Stopping",
+	Restarting: "Restarting",
+	Unknown:    "Unknown",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// a task which is done can only be restarted, a task whose worker went silent can come back in any state
+// a stop which failed leaves the container running, so stopping can go back to running
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed},
-	Completed: {},
-	Failed:    {},
+	Pending:    {Scheduled},
+	Scheduled:  {Scheduled, Pulling, Starting, Running, Stopping, Completed, Failed, Lost, Unknown},
+	Pulling:    {Starting, Stopping, Completed, Failed, Lost, Unknown},
+	Starting:   {Running, Stopping, Completed, Failed, Lost, Unknown},
+	Running:    {Running, Stopping, Restarting, Completed, Failed, Lost, Unknown},
+	Stopping:   {Running, Completed, Failed, Lost, Unknown},
+	Restarting: {Restarting, Scheduled, Pulling, Starting, Running, Stopping, Failed, Lost, Unknown},
+	Completed:  {Restarting},
+	Failed:     {Restarting},
+	Lost:       {Scheduled, Restarting},
+	Unknown:    {Scheduled, Pulling, Starting, Running, Stopping, Restarting, Completed, Failed, Lost},
 }
 
 func Contains(states []State, state State) bool {
@@ -49,26 +90,139 @@ func ValidStateTransitions(src State, dest State) bool {
 }
 
 // since this is a basic implementation of a container orchestrator
-// task here only contains the image name, the uuid, and the state
-// to find the best worker in our cluster for the application we check this by viewing memory and disk
-// also here the restart-policy is same as implemented in kubernetes while exposed-ports and port-bindings are like services
-// start-time and end-time looks cool to show in the CLI
+// task here only contains what is needed to run the container of an application and to keep track of it
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	Memory        int
-	Disk          int
-	ExposedPorts  nat.PortSet
-	HostPort      nat.PortMap
-	PortBindings  map[string]string
+	ID          uuid.UUID
+	ContainerID string
+	Name        string
+	State       State
+	Image       string
+	// memory, the memory reservation (a soft limit the node keeps free for the task) and disk are in bytes
+	// memory swap is the limit of memory and swap together like in docker, -1 means unlimited swap
+	Memory            int
+	MemoryReservation int
+	MemorySwap        int
+	Disk              int
+	// cpu is the limit in millicores, 1000 is one core, cpu shares only weigh the task against the others when the cpu is busy
+	// the pids limit caps the number of processes of the task, zero leaves any of these unlimited
+	CPU       int
+	CPUShares int
+	PidsLimit int
+	// what runs the task on the worker, docker when it is empty
+	Runtime string
+	// entrypoint and cmd replace the ones of the image, args are appended to cmd, env is a list of KEY=value
+	// the process runtime has no image, it runs entrypoint, cmd and args one after the other directly on the host
+	Entrypoint   []string
+	Cmd          []string
+	Args         []string
+	Env          []string
+	WorkingDir   string
+	Mounts       []Mount
+	ExposedPorts nat.PortSet
+	HostPort     nat.PortMap
+	PortBindings map[string]string
+	// the manager restarts the task according to its restart policy, at most max retries times (zero means no limit)
 	RestartPolicy string
+	MaxRetries    int
 	StartTime     time.Time
-	EndTime       time.Time
-	HealthCheck   string
-	RestartCount  int
+	// once the container exits, end time is when it exited and the exit code, whether it ran out of memory
+	// and the error of the runtime are kept
+	EndTime   time.Time
+	ExitCode  int
+	OOMKilled bool
+	Error     string
+	// the liveness probe, an unhealthy task is restarted like one which failed
+	// health is what the checks found and health message why the last one failed
+	HealthCheck   *HealthCheck
+	Health        string
+	HealthMessage string
+	// only decides whether the task is ready to take traffic, a task which isn't ready is left running, see IsReady
+	// both checks start after their own initial delay, a task which starts slowly needs a long enough one on its health check
+	ReadinessCheck   *HealthCheck
+	Ready            bool
+	ReadinessMessage string
+	RestartCount     int
+	// every change of the state of the task with the reason for it, see Transition
+	History []Transition
+}
+
+// the number of transitions kept in the history of a task, the oldest ones are dropped first
+const MaxHistory = 50
+
+// a change of the state of a task, why and when it happened
+type Transition struct {
+	From   State
+	To     State
+	Time   time.Time
+	Reason string
+}
+
+// moves the task to the state and records why in its history
+// staying in the same state isn't a transition, so nothing is recorded
+func (t *Task) Transition(to State, reason string) error {
+	if t.State == to {
+		return nil
+	}
+	if !ValidStateTransitions(t.State, to) {
+		return fmt.Errorf("invalid transition of task %v from %v to %v", t.ID, t.State, to)
+	}
+	t.record(to, reason)
+	return nil
+}
+
+// like Transition, but a transition which isn't allowed is only logged and leaves the task as it is
+// it reports whether the task is in the state now
+func (t *Task) TryTransition(to State, reason string) bool {
+	if err := t.Transition(to, reason); err != nil {
+		log.Printf("Error in changing the state of task %v: %v\n", t.ID, err)
+		return false
+	}
+	return true
+}
+
+// takes the state as it is, e.g. the one a worker reported, without asking whether the task may get there
+// when the history already ends in the state it explains the change, otherwise the change is recorded
+func (t *Task) SetState(s State, reason string) {
+	if n := len(t.History); n > 0 && t.History[n-1].To == s {
+		t.State = s
+		return
+	}
+	t.record(s, reason)
+}
+
+// moves the task to the state and adds it to the history, dropping the oldest transitions beyond MaxHistory
+func (t *Task) record(to State, reason string) {
+	t.History = append(t.History, Transition{From: t.State, To: to, Time: time.Now().UTC(), Reason: reason})
+	if len(t.History) > MaxHistory {
+		t.History = append([]Transition(nil), t.History[len(t.History)-MaxHistory:]...)
+	}
+	t.State = to
+}
+
+// the manager and the worker both record transitions of the task, this puts the ones of other which
+// are missing from the history in place, by time
+// the history is sorted as a new slice, copies of the task share the old one and may be read at the same time
+func (t *Task) MergeHistory(other []Transition) {
+	history := append([]Transition(nil), t.History...)
+	for _, tr := range other {
+		found := false
+		for _, h := range t.History {
+			if h.From == tr.From && h.To == tr.To && h.Time.Equal(tr.Time) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			history = append(history, tr)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+	t.History = history
 }
 
 // if user wants to stop a task it can do through task-event
@@ -79,154 +233,267 @@ type TaskEvent struct {
 	Task      Task
 }
 
-// model to run a container will sufficient configuration
-type Config struct {
-	Name          string
-	AttachStdin   bool
-	AttachStdout  bool
-	AttachStderr  bool
-	Cmd           []string
-	Image         string
-	Memory        int64
-	Disk          int64
-	Env           []string
-	RestartPolicy string
+// the runtimes a worker can run a task with
+const (
+	RuntimeDocker  = "docker"
+	RuntimeProcess = "process"
+)
+
+// when the manager restarts a task, the same as the restart policies of kubernetes
+// a task without a restart policy is restarted when it fails
+const (
+	RestartAlways    = "Always"
+	RestartOnFailure = "OnFailure"
+	RestartNever     = "Never"
+)
+
+// whether the task can take traffic, it is running and its readiness check passed (if it has one)
+func (t *Task) IsReady() bool {
+	return t.State == Running && t.Ready
 }
 
-// the docker model with the docker client and th	 configuration of the container to run
-type Docker struct {
-	Client      *client.Client
-	Config      Config
-	ContainerID string
+// whether a task which ended up in the state should be started again
+func (t *Task) ShouldRestart() bool {
+	if t.MaxRetries > 0 && t.RestartCount >= t.MaxRetries {
+		return false
+	}
+	switch t.RestartPolicy {
+	case RestartAlways:
+		return t.State == Completed || t.State == Failed
+	case RestartNever:
+		return false
+	default:
+		return t.State == Failed
+	}
 }
 
-// this will be used as a result after the task is assigned to analyze whether the docker container of executed sucessfully or not
-type DockerResult struct {
-	Error       error
-	Action      string
-	ContainerID string
-	Result      string
+// every container started for a task is labelled with the id of the task
+// so that a worker can find its containers again after a restart
+const LabelTaskID = "core.task.id"
+
+// model to run a container will sufficient configuration
+type Config struct {
+	Name              string
+	AttachStdin       bool
+	AttachStdout      bool
+	AttachStderr      bool
+	Entrypoint        []string
+	Cmd               []string
+	Args              []string
+	Image             string
+	Memory            int64
+	MemoryReservation int64
+	MemorySwap        int64
+	Disk              int64
+	NanoCPUs          int64
+	CPUShares         int64
+	PidsLimit         int64
+	Env               []string
+	WorkingDir        string
+	Labels            map[string]string
+	Mounts            []Mount
+	// every port of the container which is published, with the host ports it is published on
+	PortBindings nat.PortMap
 }
 
-// DockerInspectResponse will be usefull to determine the current state of the application
-type DockerInspectResponse struct {
-	Error     error
-	Container *types.ContainerJSON
+// the whole command line, which is what a process runs
+func (c Config) Command() []string {
+	var command []string
+	command = append(command, c.Entrypoint...)
+	command = append(command, c.Cmd...)
+	return append(command, c.Args...)
 }
 
-// This is similiar to docker run, stop, rm command
-func (d *Docker) Run() DockerResult {
-	ctx := context.Background()
-	reader, err := d.Client.ImagePull(
-		ctx, d.Config.Image, image.PullOptions{},
-	)
-	if err != nil {
-		log.Printf("Error in pulling the image %v: %v\n", d.Config, err)
-		return DockerResult{Error: err}
+// the memory the node has to keep for the task, the limit or, without one, the reservation
+func (t *Task) MemoryRequest() int {
+	if t.Memory > 0 {
+		return t.Memory
 	}
-	io.Copy(os.Stdout, reader)
+	return t.MemoryReservation
+}
 
-	rp := container.RestartPolicy{
-		Name: container.RestartPolicyMode(d.Config.RestartPolicy),
+// checks the resources, the mounts and the ports of the task before it is accepted
+func (t *Task) Validate() error {
+	for name, v := range map[string]int{
+		"Memory": t.Memory, "MemoryReservation": t.MemoryReservation, "Disk": t.Disk,
+		"CPU": t.CPU, "CPUShares": t.CPUShares, "PidsLimit": t.PidsLimit,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s can't be negative", name)
+		}
 	}
-
-	r := container.Resources{
-		Memory: d.Config.Memory,
+	if t.Memory > 0 && t.MemoryReservation > t.Memory {
+		return errors.New("MemoryReservation can't be higher than Memory")
 	}
-
-	cc := container.Config{
-		Image: d.Config.Image,
-		Env:   d.Config.Env,
+	if t.MemorySwap != 0 && t.MemorySwap != -1 {
+		if t.Memory == 0 {
+			return errors.New("MemorySwap needs Memory to be set")
+		}
+		if t.MemorySwap < t.Memory {
+			return errors.New("MemorySwap is memory and swap together, so it can't be lower than Memory")
+		}
 	}
-
-	hc := container.HostConfig{
-		RestartPolicy:   rp,
-		Resources:       r,
-		PublishAllPorts: true,
+	// docker doesn't accept shares below 2
+	if t.CPUShares == 1 {
+		return errors.New("CPUShares has to be at least 2")
 	}
-
-	resp, err := d.Client.ContainerCreate(
-		ctx, &cc, &hc, nil, nil, d.Config.Name,
-	)
-	if err != nil {
-		log.Printf("Error in creating container %v: %v", d.Config, err)
-		return DockerResult{Error: err}
+	switch t.RestartPolicy {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart policy %q, it has to be %s, %s or %s", t.RestartPolicy, RestartAlways, RestartOnFailure, RestartNever)
 	}
-
-	if err := d.Client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		log.Printf("Error in starting the container %v: %v", d.Config, err)
-		return DockerResult{Error: err}
+	if t.MaxRetries < 0 {
+		return errors.New("MaxRetries can't be negative")
+	}
+	if t.HealthCheck.Active() {
+		if err := t.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if t.ReadinessCheck.Active() {
+		if err := t.ReadinessCheck.Validate(); err != nil {
+			return fmt.Errorf("invalid readiness check: %v", err)
+		}
+	}
+	// a process sees the filesystem of the host as it is
+	if t.Runtime == RuntimeProcess && len(t.Mounts) > 0 {
+		return errors.New("the process runtime can't mount storage")
 	}
+	targets := make(map[string]bool)
+	for _, m := range t.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if targets[path.Clean(m.Target)] {
+			return fmt.Errorf("%s is mounted more than once", m.Target)
+		}
+		targets[path.Clean(m.Target)] = true
+	}
+	_, err := t.PortMap()
+	return err
+}
 
-	d.ContainerID = resp.ID
+// the kinds of storage a task can mount
+const (
+	MountVolume = "volume"
+	MountBind   = "bind"
+	MountTmpfs  = "tmpfs"
+)
 
-	out, err := d.Client.ContainerLogs(
-		ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true},
-	)
-	if err != nil {
-		log.Printf("Error in getting container logs %v: %v", d.Config, err)
-		return DockerResult{Error: err}
-	}
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
+// storage mounted at target inside the container
+// a volume is a named volume of the runtime, the worker creates it when it doesn't exist yet and removes it again
+// when the task is stopped, unless keep is set (a volume which was there before the task is never removed)
+// a bind mounts the path source of the host, a tmpfs is an empty in memory filesystem of at most size bytes
+type Mount struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+	Size     int
+	Keep     bool
+}
 
-	return DockerResult{
-		Error:       nil,
-		Action:      "start",
-		ContainerID: resp.ID,
-		Result:      "success",
+func (m Mount) Validate() error {
+	if !path.IsAbs(m.Target) {
+		return fmt.Errorf("the target of a mount has to be an absolute path, got %q", m.Target)
 	}
+	switch m.Type {
+	case MountVolume:
+		if m.Source == "" || strings.Contains(m.Source, "/") {
+			return fmt.Errorf("invalid volume name %q for %s", m.Source, m.Target)
+		}
+	case MountBind:
+		if !path.IsAbs(m.Source) {
+			return fmt.Errorf("the source of the bind mount for %s has to be an absolute path, got %q", m.Target, m.Source)
+		}
+	case MountTmpfs:
+		if m.Source != "" {
+			return fmt.Errorf("a tmpfs mount has no source, got %q for %s", m.Source, m.Target)
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q for %s", m.Type, m.Target)
+	}
+	if m.Size < 0 || (m.Size > 0 && m.Type != MountTmpfs) {
+		return fmt.Errorf("only a tmpfs mount can have a size, got %d for %s", m.Size, m.Target)
+	}
+	return nil
 }
 
-func (d *Docker) Stop(id string) DockerResult {
-	log.Printf("Attempting to stop container: %s", id)
-	ctx := context.Background()
-	if err := d.Client.ContainerStop(
-		ctx, id, container.StopOptions{},
-	); err != nil {
-		log.Printf("Error in stopping container %v: %v", d.Config, err)
-		return DockerResult{Error: err}
+// the named volumes the task mounts
+func (t *Task) Volumes() []Mount {
+	var volumes []Mount
+	for _, m := range t.Mounts {
+		if m.Type == MountVolume {
+			volumes = append(volumes, m)
+		}
 	}
+	return volumes
+}
 
-	if err := d.Client.ContainerRemove(
-		ctx, id, container.RemoveOptions{},
-	); err != nil {
-		log.Printf("Error in removing container %v: %v", d.Config, err)
-		return DockerResult{Error: err}
+// the host ports of the task, keyed by the port of the container
+// port bindings map a container port like "7777/tcp" (tcp when the protocol is left out) to "port", "ip:port" or "[ipv6]:port"
+// an exposed port without a binding, or with an empty one, is published on a random port of the host
+func (t *Task) PortMap() (nat.PortMap, error) {
+	pm := nat.PortMap{}
+	for p := range t.ExposedPorts {
+		pm[p] = []nat.PortBinding{{}}
 	}
+	for cp, hp := range t.PortBindings {
+		proto, port := nat.SplitProtoPort(cp)
+		p, err := nat.NewPort(proto, port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container port %s: %v", cp, err)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid container port %s", cp)
+		}
 
-	return DockerResult{
-		Error:       nil,
-		Action:      "stop",
-		ContainerID: id,
-		Result:      "success",
+		ip := ""
+		if strings.Contains(hp, ":") {
+			if ip, hp, err = net.SplitHostPort(hp); err != nil {
+				return nil, fmt.Errorf("invalid host binding for %s: %v", cp, err)
+			}
+			if net.ParseIP(ip) == nil {
+				return nil, fmt.Errorf("invalid host ip %s for %s", ip, cp)
+			}
+		}
+		if hp != "" {
+			if _, err := strconv.ParseUint(hp, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid host port %s for %s", hp, cp)
+			}
+		}
+		pm[p] = []nat.PortBinding{{HostIP: ip, HostPort: hp}}
 	}
+	return pm, nil
 }
 
-// This is the main inspect element
-func (d *Docker) Inspect(containerID string) DockerInspectResponse {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	ctx := context.Background()
-	resp, err := dc.ContainerInspect(ctx, containerID)
+func NewConfig(t *Task) Config {
+	pm, err := t.PortMap()
 	if err != nil {
-		log.Printf("Error in inspecting container %v : Error: %v", containerID, err)
-		return DockerInspectResponse{Error: err}
+		log.Printf("Ignoring the port bindings of task %v: %v\n", t.ID, err)
+		pm, _ = (&Task{ExposedPorts: t.ExposedPorts}).PortMap()
 	}
-	return DockerInspectResponse{Container: &resp}
-}
 
-func NewConfig(t *Task) Config {
 	return Config{
-		Name:   t.Name,
-		Image:  t.Image,
-		Memory: int64(t.Memory),
-		Disk:   int64(t.Disk),
-	}
-}
-
-func NewDocker(c Config) *Docker {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	return &Docker{
-		Client: dc,
-		Config: c,
+		Name:              t.Name,
+		Image:             t.Image,
+		Memory:            int64(t.Memory),
+		MemoryReservation: int64(t.MemoryReservation),
+		MemorySwap:        int64(t.MemorySwap),
+		Disk:              int64(t.Disk),
+		CPUShares:         int64(t.CPUShares),
+		PidsLimit:         int64(t.PidsLimit),
+		// a millicore is a million nanocpus
+		NanoCPUs:     int64(t.CPU) * 1e6,
+		Entrypoint:   t.Entrypoint,
+		Cmd:          t.Cmd,
+		Args:         t.Args,
+		Env:          t.Env,
+		WorkingDir:   t.WorkingDir,
+		Mounts:       t.Mounts,
+		PortBindings: pm,
+		Labels: map[string]string{
+			LabelTaskID: t.ID.String(),
+		},
 	}
 }
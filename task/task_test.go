@@ -0,0 +1,225 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidStateTransitions(t *testing.T) {
+	tests := []struct {
+		src, dest State
+		want      bool
+	}{
+		{Pending, Scheduled, true},
+		{Pending, Running, false},
+		{Scheduled, Running, true},
+		{Scheduled, Pending, false},
+		{Pulling, Starting, true},
+		{Starting, Running, true},
+		{Running, Stopping, true},
+		{Running, Restarting, true},
+		{Running, Scheduled, false},
+		{Stopping, Running, true},
+		{Stopping, Completed, true},
+		{Restarting, Scheduled, true},
+		{Completed, Restarting, true},
+		{Completed, Running, false},
+		{Failed, Restarting, true},
+		{Failed, Scheduled, false},
+		{Lost, Scheduled, true},
+		{Lost, Running, false},
+		{Unknown, Running, true},
+		{Unknown, Pending, false},
+		{State(42), Running, false},
+	}
+	for _, tt := range tests {
+		if got := ValidStateTransitions(tt.src, tt.dest); got != tt.want {
+			t.Errorf("ValidStateTransitions(%v, %v) = %v, want %v", tt.src, tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    State
+		to      State
+		wantErr bool
+		history int
+	}{
+		{"allowed", Scheduled, Running, false, 1},
+		{"same state", Running, Running, false, 0},
+		{"not allowed", Completed, Running, true, 0},
+		{"restart of a failed task", Failed, Restarting, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tk := Task{State: tt.from}
+			err := tk.Transition(tt.to, "because")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transition = %v, want error %v", err, tt.wantErr)
+			}
+			want := tt.to
+			if tt.wantErr {
+				want = tt.from
+			}
+			if tk.State != want {
+				t.Errorf("state = %v, want %v", tk.State, want)
+			}
+			if len(tk.History) != tt.history {
+				t.Fatalf("%d transitions recorded, want %d", len(tk.History), tt.history)
+			}
+			if tt.history > 0 {
+				if h := tk.History[0]; h.From != tt.from || h.To != tt.to || h.Reason != "because" || h.Time.IsZero() {
+					t.Errorf("recorded %+v", h)
+				}
+			}
+		})
+	}
+}
+
+func TestTransitionTrimsHistory(t *testing.T) {
+	tk := Task{State: Running}
+	for i := 0; i < MaxHistory+10; i++ {
+		next := Restarting
+		if tk.State == Restarting {
+			next = Running
+		}
+		if err := tk.Transition(next, "flapping"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tk.History) != MaxHistory {
+		t.Fatalf("history has %d transitions, want %d", len(tk.History), MaxHistory)
+	}
+	// the oldest ones were dropped, so the last one is still the latest transition
+	if last := tk.History[MaxHistory-1]; last.To != tk.State {
+		t.Errorf("last transition goes to %v, want %v", last.To, tk.State)
+	}
+	if cap(tk.History) > 2*MaxHistory {
+		t.Errorf("history keeps a backing array of %d", cap(tk.History))
+	}
+}
+
+func TestTryTransition(t *testing.T) {
+	tk := Task{State: Completed}
+	if tk.TryTransition(Running, "because") || tk.State != Completed || len(tk.History) != 0 {
+		t.Errorf("transition which isn't allowed left the task %v with %d transitions", tk.State, len(tk.History))
+	}
+	if !tk.TryTransition(Restarting, "because") || tk.State != Restarting {
+		t.Errorf("allowed transition left the task %v", tk.State)
+	}
+}
+
+func TestSetState(t *testing.T) {
+	// taken as it is, even where a transition isn't allowed
+	tk := Task{State: Completed}
+	tk.SetState(Running, "reported")
+	if tk.State != Running || len(tk.History) != 1 || tk.History[0].From != Completed || tk.History[0].Reason != "reported" {
+		t.Fatalf("task is %v with history %+v", tk.State, tk.History)
+	}
+	// the history already explains the state
+	tk.State = Failed
+	tk.History = append(tk.History, Transition{From: Running, To: Completed})
+	tk.SetState(Completed, "reported")
+	if tk.State != Completed || len(tk.History) != 2 {
+		t.Errorf("task is %v with %d transitions, want the last one to explain it", tk.State, len(tk.History))
+	}
+
+	for i := 0; i < MaxHistory; i++ {
+		tk.SetState(State(i%2+int(Running)), "flapping")
+	}
+	if len(tk.History) != MaxHistory {
+		t.Errorf("history has %d transitions, want %d", len(tk.History), MaxHistory)
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	now := time.Now().UTC()
+	at := func(s int) time.Time { return now.Add(time.Duration(s) * time.Second) }
+	tk := Task{History: []Transition{
+		{From: Pending, To: Scheduled, Time: at(0)},
+		{From: Running, To: Stopping, Time: at(3)},
+	}}
+	tk.MergeHistory([]Transition{
+		{From: Scheduled, To: Running, Time: at(1)},
+		// recorded by both, it must not show up twice
+		{From: Running, To: Stopping, Time: at(3)},
+	})
+
+	want := []State{Scheduled, Running, Stopping}
+	if len(tk.History) != len(want) {
+		t.Fatalf("merged history %+v", tk.History)
+	}
+	for i, s := range want {
+		if tk.History[i].To != s {
+			t.Errorf("transition %d goes to %v, want %v", i, tk.History[i].To, s)
+		}
+	}
+
+	var other []Transition
+	for i := 0; i < MaxHistory; i++ {
+		other = append(other, Transition{From: Running, To: Restarting, Time: at(10 + i)})
+	}
+	tk.MergeHistory(other)
+	if len(tk.History) != MaxHistory || !tk.History[0].Time.Equal(at(10)) {
+		t.Errorf("merged history has %d transitions starting at %v, want the newest %d", len(tk.History), tk.History[0].Time, MaxHistory)
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy   string
+		state    State
+		restarts int
+		max      int
+		want     bool
+	}{
+		{"", Failed, 0, 0, true},
+		{"", Completed, 0, 0, false},
+		{RestartOnFailure, Failed, 0, 0, true},
+		{RestartOnFailure, Completed, 0, 0, false},
+		{RestartAlways, Failed, 0, 0, true},
+		{RestartAlways, Completed, 0, 0, true},
+		{RestartAlways, Running, 0, 0, false},
+		{RestartNever, Failed, 0, 0, false},
+		{RestartNever, Completed, 0, 0, false},
+		// no limit without max retries
+		{RestartAlways, Failed, 100, 0, true},
+		{RestartAlways, Failed, 2, 3, true},
+		{RestartAlways, Failed, 3, 3, false},
+		{"", Failed, 4, 3, false},
+	}
+	for _, tt := range tests {
+		tk := Task{RestartPolicy: tt.policy, State: tt.state, RestartCount: tt.restarts, MaxRetries: tt.max}
+		if got := tk.ShouldRestart(); got != tt.want {
+			t.Errorf("ShouldRestart of a %v task with policy %q after %d of %d retries = %v, want %v", tt.state, tt.policy, tt.restarts, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestMountValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mount   Mount
+		wantErr bool
+	}{
+		{"volume", Mount{Type: MountVolume, Source: "data", Target: "/data"}, false},
+		{"read only bind", Mount{Type: MountBind, Source: "/etc/config", Target: "/config", ReadOnly: true}, false},
+		{"tmpfs with a size", Mount{Type: MountTmpfs, Target: "/tmp", Size: 64 << 20}, false},
+		{"relative target", Mount{Type: MountVolume, Source: "data", Target: "data"}, true},
+		{"relative bind source", Mount{Type: MountBind, Source: "config", Target: "/config"}, true},
+		{"bind without a source", Mount{Type: MountBind, Target: "/config"}, true},
+		{"volume without a name", Mount{Type: MountVolume, Target: "/data"}, true},
+		{"volume name with a path", Mount{Type: MountVolume, Source: "data/sub", Target: "/data"}, true},
+		{"tmpfs with a source", Mount{Type: MountTmpfs, Source: "/tmp", Target: "/tmp"}, true},
+		{"size of a volume", Mount{Type: MountVolume, Source: "data", Target: "/data", Size: 1024}, true},
+		{"negative size", Mount{Type: MountTmpfs, Target: "/tmp", Size: -1}, true},
+		{"unknown type", Mount{Type: "nfs", Source: "server:/export", Target: "/data"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.mount.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/hanshal101/core/task"
+)
+
+// where the checks of a container stand, a restarted task gets a new container and so starts over
+type probe struct {
+	running   bool
+	next      time.Time
+	successes int
+	failures  int
+}
+
+// the liveness and readiness probes of a container
+// they run independently, a slow start is covered by the initial delay of the health check
+// so a task which hangs before it ever gets ready is still restarted
+type probes struct {
+	liveness  probe
+	readiness probe
+}
+
+// runs the health and readiness checks of the running tasks, every check on its own interval
+func (w *Worker) CheckHealth() {
+	for {
+		w.checkHealth()
+		time.Sleep(time.Second)
+	}
+}
+
+// whether the check is due, it is marked as running then
+func (p *probe) due(now time.Time, h task.HealthCheck) bool {
+	if p.running || now.Before(p.next) {
+		return false
+	}
+	p.running = true
+	p.next = now.Add(time.Duration(h.IntervalSeconds) * time.Second)
+	return true
+}
+
+func (w *Worker) checkHealth() {
+	now := time.Now().UTC()
+	w.mu.Lock()
+	running := make(map[string]bool)
+	type check struct {
+		t         task.Task
+		readiness bool
+	}
+	var due []check
+	for _, t := range w.DB {
+		live, ready := t.HealthCheck.Active(), t.ReadinessCheck.Active()
+		if t.State != task.Running || (!live && !ready) {
+			continue
+		}
+		running[t.ContainerID] = true
+		ps, ok := w.probes[t.ContainerID]
+		if !ok {
+			ps = &probes{}
+			if live {
+				ps.liveness.next = t.StartTime.Add(time.Duration(t.HealthCheck.InitialDelaySeconds) * time.Second)
+			}
+			if ready {
+				ps.readiness.next = t.StartTime.Add(time.Duration(t.ReadinessCheck.InitialDelaySeconds) * time.Second)
+			}
+			w.probes[t.ContainerID] = ps
+		}
+		if ready && ps.readiness.due(now, t.ReadinessCheck.Defaults()) {
+			due = append(due, check{t: *t, readiness: true})
+		}
+		if live && ps.liveness.due(now, t.HealthCheck.Defaults()) {
+			due = append(due, check{t: *t})
+		}
+	}
+	for id := range w.probes {
+		if !running[id] {
+			delete(w.probes, id)
+		}
+	}
+	w.mu.Unlock()
+
+	// the checks can take up to their timeout, so they don't hold up each other
+	for _, c := range due {
+		go w.probe(c.t, c.readiness)
+	}
+}
+
+// runs the liveness or the readiness check once, the task only changes once the check failed or passed often enough in a row
+func (w *Worker) probe(t task.Task, readiness bool) {
+	h := t.HealthCheck.Defaults()
+	if readiness {
+		h = t.ReadinessCheck.Defaults()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.TimeoutSeconds)*time.Second)
+	defer cancel()
+	err := w.runCheck(ctx, t, h)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ps, ok := w.probes[t.ContainerID]
+	if !ok {
+		return
+	}
+	p := &ps.liveness
+	if readiness {
+		p = &ps.readiness
+	}
+	p.running = false
+	// the task was stopped or restarted while it was checked
+	cur, ok := w.DB[t.ID]
+	if !ok || cur.State != task.Running || cur.ContainerID != t.ContainerID {
+		return
+	}
+
+	msg := ""
+	if err != nil {
+		p.failures++
+		p.successes = 0
+		msg = err.Error()
+	} else {
+		p.successes++
+		p.failures = 0
+	}
+	failed := err != nil && p.failures >= h.FailureThreshold
+	passed := err == nil && p.successes >= h.SuccessThreshold
+
+	if readiness {
+		ready := cur.Ready
+		if failed {
+			ready = false
+		}
+		if passed {
+			ready = true
+		}
+		if ready == cur.Ready && msg == cur.ReadinessMessage {
+			return
+		}
+		if ready != cur.Ready {
+			log.Printf("Task %v is ready: %v %s\n", t.ID, ready, msg)
+		}
+		cur.Ready = ready
+		cur.ReadinessMessage = msg
+		w.saveTask(cur)
+		return
+	}
+
+	health := cur.Health
+	if failed {
+		health = task.HealthUnhealthy
+	}
+	if passed {
+		health = task.HealthHealthy
+	}
+	if health == cur.Health && msg == cur.HealthMessage {
+		return
+	}
+	if health != cur.Health {
+		log.Printf("Task %v is %s: %s\n", t.ID, strings.ToLower(health), msg)
+	}
+	cur.Health = health
+	cur.HealthMessage = msg
+	w.saveTask(cur)
+}
+
+func (w *Worker) runCheck(ctx context.Context, t task.Task, h task.HealthCheck) error {
+	if h.Type == task.HealthExec {
+		rt, err := w.runtime(t)
+		if err != nil {
+			return err
+		}
+		r, err := rt.Exec(ctx, t.ContainerID, h.Command)
+		if err != nil {
+			return fmt.Errorf("error in running %v: %v", h.Command, err)
+		}
+		if r.ExitCode != 0 {
+			return fmt.Errorf("%v exited with code %d: %s", h.Command, r.ExitCode, strings.TrimSpace(r.Output))
+		}
+		return nil
+	}
+
+	addr, err := checkAddress(t, h)
+	if err != nil {
+		return err
+	}
+	switch h.Type {
+	case task.HealthHTTP:
+		return checkHTTP(ctx, addr, h)
+	case task.HealthTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	case task.HealthGRPC:
+		return checkGRPC(ctx, addr, h)
+	default:
+		return fmt.Errorf("unknown health check type %q", h.Type)
+	}
+}
+
+// the address of the host port the container port of the check is published on
+// the checks run on the host of the container, so a port published on every address is reached on localhost
+func checkAddress(t task.Task, h task.HealthCheck) (string, error) {
+	p, err := h.ContainerPort()
+	if err != nil {
+		return "", err
+	}
+	var bindings []nat.PortBinding
+	if p != nil {
+		bindings = t.HostPort[*p]
+	} else {
+		ports := make([]nat.Port, 0, len(t.HostPort))
+		for port := range t.HostPort {
+			if port.Proto() == "tcp" && len(t.HostPort[port]) > 0 {
+				ports = append(ports, port)
+			}
+		}
+		sort.Slice(ports, func(i, j int) bool { return ports[i].Int() < ports[j].Int() })
+		if len(ports) > 0 {
+			bindings = t.HostPort[ports[0]]
+		}
+	}
+	if len(bindings) == 0 || bindings[0].HostPort == "" {
+		return "", fmt.Errorf("no published port for the health check of task %v", t.ID)
+	}
+
+	ip := bindings[0].HostIP
+	if parsed := net.ParseIP(ip); ip == "" || (parsed != nil && parsed.IsUnspecified()) {
+		ip = "127.0.0.1"
+	}
+	return net.JoinHostPort(ip, bindings[0].HostPort), nil
+}
+
+func checkHTTP(ctx context.Context, addr string, h task.HealthCheck) error {
+	req, err := http.NewRequestWithContext(ctx, h.Method, fmt.Sprintf("http://%s%s", addr, h.Path), nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	if host, ok := h.Headers["Host"]; ok {
+		req.Host = host
+	}
+	// a redirect is an answer as well, it isn't followed
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if h.Status != 0 && resp.StatusCode != h.Status {
+		return fmt.Errorf("%s %s returned %d instead of %d", h.Method, h.Path, resp.StatusCode, h.Status)
+	}
+	if h.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("%s %s returned %d", h.Method, h.Path, resp.StatusCode)
+	}
+	return nil
+}
+
+// the standard grpc health checking protocol, see https://github.com/grpc/grpc/blob/master/doc/health-checking.md
+func checkGRPC(ctx context.Context, addr string, h task.HealthCheck) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: h.Service})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc service %q is %s", h.Service, resp.Status)
+	}
+	return nil
+}
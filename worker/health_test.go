@@ -0,0 +1,316 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/hanshal101/core/task"
+)
+
+// runs the checks until cond holds for the task
+func checkUntil(t *testing.T, w *Worker, id uuid.UUID, what string, cond func(task.Task) bool) task.Task {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.checkHealth()
+		got, _ := w.GetTask(id)
+		if cond(got) {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, task is %+v", what, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLivenessWithoutReadiness(t *testing.T) {
+	w, rt := newTestWorker()
+	check := &task.HealthCheck{Type: task.HealthExec, Command: []string{"check"}, IntervalSeconds: 1, FailureThreshold: 1}
+	tk := run(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled, HealthCheck: check, ReadinessCheck: check})
+	if tk.Health != task.HealthStarting || tk.Ready {
+		t.Fatalf("started task is %s and ready %v", tk.Health, tk.Ready)
+	}
+
+	// the task hangs before it ever gets ready, the liveness check still finds out
+	rt.SetExec(tk.ContainerID, task.ExecResult{ExitCode: 1, Output: "hanging"})
+	got := checkUntil(t, w, tk.ID, "the task to be unhealthy", func(t task.Task) bool { return t.Health == task.HealthUnhealthy })
+	if got.Ready {
+		t.Error("hanging task is ready")
+	}
+}
+
+func TestLivenessInitialDelay(t *testing.T) {
+	w, rt := newTestWorker()
+	tk := run(t, w, task.Task{
+		ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled,
+		HealthCheck:    &task.HealthCheck{Type: task.HealthExec, Command: []string{"live"}, InitialDelaySeconds: 60, FailureThreshold: 1},
+		ReadinessCheck: &task.HealthCheck{Type: task.HealthExec, Command: []string{"ready"}, IntervalSeconds: 1, FailureThreshold: 1},
+	})
+
+	// the readiness check runs right away, the liveness check waits for its initial delay
+	rt.SetExec(tk.ContainerID, task.ExecResult{ExitCode: 1})
+	got := checkUntil(t, w, tk.ID, "the readiness check to fail", func(t task.Task) bool { return t.ReadinessMessage != "" })
+	if got.Health != task.HealthStarting {
+		t.Errorf("task is %s before the initial delay of its health check is over", got.Health)
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		check   task.HealthCheck
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, task.HealthCheck{}, false},
+		{"no content", http.StatusNoContent, task.HealthCheck{}, false},
+		// the redirect isn't followed, it counts as an answer
+		{"redirect", http.StatusFound, task.HealthCheck{}, false},
+		{"not found", http.StatusNotFound, task.HealthCheck{}, true},
+		{"server error", http.StatusInternalServerError, task.HealthCheck{}, true},
+		{"last of the 3xx", 399, task.HealthCheck{}, false},
+		{"bad request", http.StatusBadRequest, task.HealthCheck{}, true},
+		{"expected status", http.StatusTeapot, task.HealthCheck{Status: http.StatusTeapot}, false},
+		{"other than the expected status", http.StatusOK, task.HealthCheck{Status: http.StatusNoContent}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusFound {
+					http.Redirect(w, r, "http://127.0.0.1:1/", tt.status)
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer s.Close()
+			h := tt.check
+			h.Type = task.HealthHTTP
+			err := checkHTTP(context.Background(), strings.TrimPrefix(s.URL, "http://"), h.Defaults())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckHTTPRequest(t *testing.T) {
+	var got *http.Request
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer s.Close()
+	h := task.HealthCheck{Type: task.HealthHTTP, Path: "/healthz", Method: http.MethodHead, Headers: map[string]string{"X-Probe": "core", "Host": "web.local"}}
+	if err := checkHTTP(context.Background(), strings.TrimPrefix(s.URL, "http://"), h.Defaults()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodHead || got.URL.Path != "/healthz" || got.Header.Get("X-Probe") != "core" || got.Host != "web.local" {
+		t.Errorf("got %s %s with host %s and headers %v", got.Method, got.URL.Path, got.Host, got.Header)
+	}
+
+	// the defaults
+	h = task.HealthCheck{Type: task.HealthHTTP}
+	if err := checkHTTP(context.Background(), strings.TrimPrefix(s.URL, "http://"), h.Defaults()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodGet || got.URL.Path != "/" {
+		t.Errorf("got %s %s, want GET /", got.Method, got.URL.Path)
+	}
+}
+
+func TestCheckHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	h := task.HealthCheck{Type: task.HealthHTTP}
+	if err := checkHTTP(ctx, strings.TrimPrefix(s.URL, "http://"), h.Defaults()); err == nil {
+		t.Error("check of a server which never answers passed")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("check took %v", took)
+	}
+}
+
+func TestCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	tk := task.Task{HostPort: nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: port}}}}
+	h := task.HealthCheck{Type: task.HealthTCP}.Defaults()
+	w, _ := newTestWorker()
+
+	if err := w.runCheck(context.Background(), tk, h); err != nil {
+		t.Errorf("check of a listening port = %v", err)
+	}
+	l.Close()
+	if err := w.runCheck(context.Background(), tk, h); err == nil {
+		t.Error("check of a closed port passed")
+	}
+	if err := w.runCheck(context.Background(), task.Task{}, h); err == nil {
+		t.Error("check of a task without a published port passed")
+	}
+}
+
+func TestCheckGRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := health.NewServer()
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(l)
+	defer s.Stop()
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("web", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("db", healthpb.HealthCheckResponse_NOT_SERVING)
+	tests := []struct {
+		service string
+		wantErr bool
+	}{
+		{"", false},
+		{"web", false},
+		{"db", true},
+		{"unknown", true},
+	}
+	for _, tt := range tests {
+		h := task.HealthCheck{Type: task.HealthGRPC, Service: tt.service}
+		err := checkGRPC(context.Background(), l.Addr().String(), h.Defaults())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("check of service %q = %v, want error %v", tt.service, err, tt.wantErr)
+		}
+	}
+
+	// the whole server stops serving
+	hs.Shutdown()
+	h := task.HealthCheck{Type: task.HealthGRPC}
+	if err := checkGRPC(context.Background(), l.Addr().String(), h.Defaults()); err == nil {
+		t.Error("check of a server which is shutting down passed")
+	}
+}
+
+// runs the liveness check of the task once, without waiting for its interval
+func checkNow(t *testing.T, w *Worker, tk task.Task) task.Task {
+	t.Helper()
+	w.mu.Lock()
+	if ps, ok := w.probes[tk.ContainerID]; ok {
+		ps.liveness.next = time.Time{}
+	}
+	w.mu.Unlock()
+	w.checkHealth()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mu.Lock()
+		running := w.probes[tk.ContainerID].liveness.running
+		w.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the check")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got, _ := w.GetTask(tk.ID)
+	return got
+}
+
+func TestHealthThresholds(t *testing.T) {
+	var healthy atomic.Bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name  string
+		addr  string
+		check task.HealthCheck
+		// makes the service healthy or not
+		set func(t *testing.T, ok bool)
+	}{
+		{"http", strings.TrimPrefix(s.URL, "http://"), task.HealthCheck{Type: task.HealthHTTP}, func(t *testing.T, ok bool) { healthy.Store(ok) }},
+		{"tcp", l.Addr().String(), task.HealthCheck{Type: task.HealthTCP}, func(t *testing.T, ok bool) {
+			addr := l.Addr().String()
+			l.Close()
+			if ok {
+				if l, err = net.Listen("tcp", addr); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := newTestWorker()
+			check := tt.check
+			check.Port = "80/tcp"
+			check.SuccessThreshold = 2
+			check.FailureThreshold = 3
+			tk := run(t, w, task.Task{
+				ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled,
+				PortBindings: map[string]string{"80/tcp": tt.addr}, HealthCheck: &check,
+			})
+
+			tt.set(t, true)
+			if got := checkNow(t, w, tk); got.Health != task.HealthStarting {
+				t.Fatalf("task is %s after one of two passed checks", got.Health)
+			}
+			if got := checkNow(t, w, tk); got.Health != task.HealthHealthy {
+				t.Fatalf("task is %s after two passed checks", got.Health)
+			}
+
+			tt.set(t, false)
+			for i := 1; i < check.FailureThreshold; i++ {
+				if got := checkNow(t, w, tk); got.Health != task.HealthHealthy || got.HealthMessage == "" {
+					t.Fatalf("task is %s (%q) after %d failed checks", got.Health, got.HealthMessage, i)
+				}
+			}
+			if got := checkNow(t, w, tk); got.Health != task.HealthUnhealthy {
+				t.Fatalf("task is %s after %d failed checks", got.Health, check.FailureThreshold)
+			}
+
+			// a passed check in between starts the count over
+			tt.set(t, true)
+			checkNow(t, w, tk)
+			tt.set(t, false)
+			checkNow(t, w, tk)
+			tt.set(t, true)
+			if got := checkNow(t, w, tk); got.Health != task.HealthUnhealthy {
+				t.Errorf("task is %s without two passed checks in a row", got.Health)
+			}
+			if got := checkNow(t, w, tk); got.Health != task.HealthHealthy || got.HealthMessage != "" {
+				t.Errorf("task is %s (%q) after two passed checks in a row", got.Health, got.HealthMessage)
+			}
+		})
+	}
+}
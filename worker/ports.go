@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/task"
+)
+
+// the range dynamic host ports are handed out from by default, the same as the node ports of kubernetes
+const (
+	DefaultPortRangeStart = 30000
+	DefaultPortRangeEnd   = 32767
+)
+
+// keeps track of the host ports of the worker and which task holds them
+// tasks which don't ask for a fixed host port get the next free one of the range
+// the fixed ones are tracked as well, so that they are never handed out to another task
+type PortAllocator struct {
+	mu    sync.Mutex
+	Start int
+	End   int
+	next  int
+	used  map[string]uuid.UUID
+}
+
+func NewPortAllocator(start int, end int) *PortAllocator {
+	return &PortAllocator{
+		Start: start,
+		End:   end,
+		used:  make(map[string]uuid.UUID),
+	}
+}
+
+func portKey(proto string, port string) string {
+	return fmt.Sprintf("%s/%s", port, proto)
+}
+
+// whether nothing else on the host listens on the port
+func hostPortFree(proto string, port int) bool {
+	addr := fmt.Sprintf(":%d", port)
+	if proto == "udp" {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// hands out the next free port of the range, the ports are handed out in turn so that a released one isn't reused right away
+func (a *PortAllocator) Allocate(id uuid.UUID, proto string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	size := a.End - a.Start + 1
+	for i := 0; i < size; i++ {
+		port := a.Start + (a.next+i)%size
+		key := portKey(proto, strconv.Itoa(port))
+		if _, ok := a.used[key]; ok {
+			continue
+		}
+		if !hostPortFree(proto, port) {
+			continue
+		}
+		a.used[key] = id
+		a.next = (a.next + i + 1) % size
+		return strconv.Itoa(port), nil
+	}
+	return "", fmt.Errorf("no free %s port left in the range %d-%d", proto, a.Start, a.End)
+}
+
+// takes a fixed port for the task, it fails if another task holds the port already
+func (a *PortAllocator) Reserve(id uuid.UUID, proto string, port string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := portKey(proto, port)
+	if holder, ok := a.used[key]; ok && holder != id {
+		return fmt.Errorf("host port %s is already allocated to task %v", key, holder)
+	}
+	a.used[key] = id
+	return nil
+}
+
+// gives back every port held by the task
+func (a *PortAllocator) Release(id uuid.UUID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, holder := range a.used {
+		if holder == id {
+			delete(a.used, key)
+		}
+	}
+}
+
+// the allocation table, port/protocol to task
+func (a *PortAllocator) Allocations() map[string]uuid.UUID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	used := make(map[string]uuid.UUID, len(a.used))
+	for key, id := range a.used {
+		used[key] = id
+	}
+	return used
+}
+
+// gives every binding of the task a host port, the ones without a fixed port get one from the range
+// a process can't be mapped to another port, so it holds its own ports
+func (w *Worker) bindPorts(t task.Task, bindings nat.PortMap) (nat.PortMap, error) {
+	bound := nat.PortMap{}
+	for p, bs := range bindings {
+		for _, b := range bs {
+			var err error
+			switch {
+			case b.HostPort != "":
+				err = w.Ports.Reserve(t.ID, p.Proto(), b.HostPort)
+			case t.Runtime == task.RuntimeProcess:
+				b.HostPort = p.Port()
+				err = w.Ports.Reserve(t.ID, p.Proto(), b.HostPort)
+			default:
+				b.HostPort, err = w.Ports.Allocate(t.ID, p.Proto())
+			}
+			if err != nil {
+				w.Ports.Release(t.ID)
+				return nil, err
+			}
+			bound[p] = append(bound[p], b)
+		}
+	}
+	return bound, nil
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// the start of size ports in a row nothing on the host listens on
+func freeRange(t *testing.T, size int) int {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		free := true
+		for p := start; p < start+size && free; p++ {
+			free = hostPortFree("tcp", p) && hostPortFree("udp", p)
+		}
+		if free {
+			return start
+		}
+	}
+	t.Fatalf("no %d free ports in a row", size)
+	return 0
+}
+
+func TestPortAllocator(t *testing.T) {
+	type step struct {
+		op   string
+		task int
+		// relative to the start of the range
+		port    int
+		proto   string
+		wantErr bool
+	}
+	tests := []struct {
+		name  string
+		size  int
+		steps []step
+	}{
+		{"conflicting reserve", 3, []step{
+			{op: "reserve", task: 0, port: 5},
+			{op: "reserve", task: 1, port: 5, wantErr: true},
+			// the holder can reserve it again
+			{op: "reserve", task: 0, port: 5},
+			{op: "reserve", task: 1, port: 5, proto: "udp"},
+			{op: "release", task: 0},
+			{op: "reserve", task: 1, port: 5},
+		}},
+		{"reserved port isn't allocated", 2, []step{
+			{op: "reserve", task: 0, port: 0},
+			{op: "allocate", task: 1, port: 1},
+			{op: "allocate", task: 2, wantErr: true},
+		}},
+		{"released port is reused", 2, []step{
+			{op: "allocate", task: 0, port: 0},
+			{op: "release", task: 0},
+			// handed out in turn, so the released one comes around again only after the others
+			{op: "allocate", task: 1, port: 1},
+			{op: "allocate", task: 2, port: 0},
+		}},
+		{"exhausted range", 2, []step{
+			{op: "allocate", task: 0, port: 0},
+			{op: "allocate", task: 1, port: 1},
+			{op: "allocate", task: 2, wantErr: true},
+			{op: "allocate", task: 2, proto: "udp", port: 0},
+			{op: "release", task: 1},
+			{op: "allocate", task: 2, port: 1},
+		}},
+		{"empty range", 0, []step{
+			{op: "allocate", task: 0, wantErr: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := freeRange(t, tt.size)
+			a := NewPortAllocator(start, start+tt.size-1)
+			ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+			for i, s := range tt.steps {
+				proto := s.proto
+				if proto == "" {
+					proto = "tcp"
+				}
+				var err error
+				switch s.op {
+				case "reserve":
+					err = a.Reserve(ids[s.task], proto, strconv.Itoa(start+s.port))
+				case "allocate":
+					var port string
+					port, err = a.Allocate(ids[s.task], proto)
+					if err == nil && port != strconv.Itoa(start+s.port) {
+						t.Errorf("step %d: allocated %s, want %d", i, port, start+s.port)
+					}
+				case "release":
+					a.Release(ids[s.task])
+				}
+				if (err != nil) != s.wantErr {
+					t.Fatalf("step %d: %s = %v, want error %v", i, s.op, err, s.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestPortAllocatorSkipsBusyPorts(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	a := NewPortAllocator(port, port)
+	if got, err := a.Allocate(uuid.New(), "tcp"); err == nil {
+		t.Fatalf("allocated port %s which another process listens on", got)
+	}
+	l.Close()
+	if got, err := a.Allocate(uuid.New(), "tcp"); err != nil || got != strconv.Itoa(port) {
+		t.Errorf("allocated %s, %v once the port is free again", got, err)
+	}
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// sent to the manager when the worker starts, so that the manager can add it to the cluster
+// memory and disk are in bytes, the port range is where the worker hands out the host ports of the tasks from
+type Registration struct {
+	Name           string
+	Address        string
+	Cores          int
+	Memory         int
+	Disk           int
+	PortRangeStart int
+	PortRangeEnd   int
+}
+
+func (w *Worker) registration() Registration {
+	mem := GetMemoryInfo()
+	disk := GetDiskInfo()
+	return Registration{
+		Name:           w.Name,
+		Address:        w.Address,
+		Cores:          runtime.NumCPU(),
+		Memory:         int(mem.MemTotal * 1024),
+		Disk:           int(disk.All),
+		PortRangeStart: w.Ports.Start,
+		PortRangeEnd:   w.Ports.End,
+	}
+}
+
+// address of the manager replica the worker is currently talking to
+// any replica will do, the followers redirect the worker to the leader
+func (w *Worker) currentManager() string {
+	if len(w.Managers) == 0 {
+		return ""
+	}
+	return w.Managers[w.manager%len(w.Managers)]
+}
+
+// the current replica can't be reached, so try the next one
+func (w *Worker) nextManager() {
+	w.manager = (w.manager + 1) % max(len(w.Managers), 1)
+}
+
+// registers the worker with the manager
+func (w *Worker) Register() error {
+	data, err := json.Marshal(w.registration())
+	if err != nil {
+		return fmt.Errorf("error in json marshal: %v", err)
+	}
+
+	url := fmt.Sprintf("http://%s/workers", w.currentManager())
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		w.nextManager()
+		return fmt.Errorf("error in connecting to manager at %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		e := ErrResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return fmt.Errorf("error in registering with the manager (%d)", resp.StatusCode)
+		}
+		return fmt.Errorf("error in registering with the manager (%d): %s", e.HTTPStatusCode, e.Message)
+	}
+
+	log.Printf("Registered worker %s with manager %s\n", w.Name, w.currentManager())
+	return nil
+}
+
+// sends a heartbeat to the manager every interval
+// the worker registers (again) whenever the manager doesn't know about it, e.g. on startup or after the manager restarted
+func (w *Worker) SendHeartbeats(interval time.Duration) {
+	registered := false
+	for {
+		if !registered {
+			if err := w.Register(); err != nil {
+				log.Printf("Error in registering worker: %v\n", err)
+			} else {
+				registered = true
+			}
+		} else {
+			registered = w.heartbeat()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// returns false if the worker has to register again
+func (w *Worker) heartbeat() bool {
+	url := fmt.Sprintf("http://%s/workers/%s/heartbeat", w.currentManager(), w.Name)
+	req, err := http.NewRequest(http.MethodPut, url, nil)
+	if err != nil {
+		log.Printf("Error in creating heartbeat request: %v\n", err)
+		return true
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error in sending heartbeat to %s: %v\n", url, err)
+		w.nextManager()
+		return true
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Printf("Manager does not know worker %s, registering again\n", w.Name)
+		return false
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		log.Printf("Error in sending heartbeat (%d)\n", resp.StatusCode)
+	}
+	return true
+}
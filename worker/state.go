@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/task"
+)
+
+// bucket in which the worker keeps its tasks
+const tasksBucket = "tasks"
+
+func (w *Worker) saveTask(t *task.Task) {
+	if w.Store == nil {
+		return
+	}
+	if err := w.Store.Put(tasksBucket, t.ID.String(), t); err != nil {
+		log.Printf("Error in saving task %v: %v\n", t.ID, err)
+	}
+}
+
+// loads the tasks from the store, this has to be called once on boot before any of the loops start
+func (w *Worker) Restore() error {
+	if w.Store == nil {
+		return nil
+	}
+	tasks, err := w.Store.List(tasksBucket)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, data := range tasks {
+		var t task.Task
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.Printf("Error in decoding stored task: %v\n", err)
+			continue
+		}
+		w.DB[t.ID] = &t
+	}
+	log.Printf("Restored %d tasks\n", len(w.DB))
+	return nil
+}
+
+// brings the task db in line with the containers which are actually there
+// running containers of our tasks are adopted again, while tasks whose container is gone are marked as failed
+// a runtime which can't be asked leaves its tasks alone
+func (w *Worker) Reconcile() {
+	// the runtime a container belongs to, by the name a task uses for it
+	type runtimeContainer struct {
+		runtime string
+		task.ContainerInfo
+	}
+	var containers []runtimeContainer
+	unavailable := make(map[string]bool)
+	for name, rt := range w.allRuntimes() {
+		infos, err := rt.List(context.Background())
+		if err != nil {
+			log.Printf("Error in reconciling tasks with the runtime %q: %v\n", name, err)
+			unavailable[name] = true
+			continue
+		}
+		for _, c := range infos {
+			// listing doesn't tell how a container exited
+			if c.Status == "exited" {
+				if info, err := rt.Inspect(context.Background(), c.ID); err == nil {
+					c = info
+				}
+			}
+			containers = append(containers, runtimeContainer{runtime: name, ContainerInfo: c})
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	found := make(map[uuid.UUID]bool)
+	for _, c := range containers {
+		id, err := uuid.Parse(c.Labels[task.LabelTaskID])
+		if err != nil {
+			log.Printf("Container %s has an invalid task id label: %v\n", c.ID, err)
+			continue
+		}
+
+		t, ok := w.DB[id]
+		if !ok {
+			if c.Status != "running" {
+				continue
+			}
+			// we lost track of the task but its container is still running, so we take it back
+			t = &task.Task{
+				ID:        id,
+				Name:      c.Name,
+				State:     task.Running,
+				Image:     c.Image,
+				Runtime:   c.runtime,
+				StartTime: c.Created,
+				Ready:     true,
+				History: []task.Transition{
+					{From: task.Pending, To: task.Running, Time: time.Now().UTC(), Reason: fmt.Sprintf("adopted running container %s", c.ID)},
+				},
+			}
+			w.DB[id] = t
+			log.Printf("Adopted running container %s of task %v\n", c.ID, id)
+		}
+
+		// a task can have more than one container (e.g. it was restarted), the running one wins
+		if found[id] && c.Status != "running" {
+			continue
+		}
+		found[id] = true
+		t.ContainerID = c.ID
+		if c.Status == "running" {
+			t.TryTransition(task.Running, fmt.Sprintf("container %s is running", c.ID))
+		} else if active(t.State) && c.Status == "exited" {
+			log.Printf("Container %s of task %v has exited with code %d\n", c.ID, id, c.ExitCode)
+			w.exited(t, c.ContainerInfo)
+		} else if active(t.State) {
+			log.Printf("Container %s of task %v is %s, marking the task as failed\n", c.ID, id, c.Status)
+			t.TryTransition(task.Failed, fmt.Sprintf("container %s is %s", c.ID, c.Status))
+			w.Ports.Release(t.ID)
+		}
+		w.saveTask(t)
+	}
+
+	for id, t := range w.DB {
+		if found[id] || unavailable[w.runtimeName(t.Runtime)] {
+			continue
+		}
+		if active(t.State) {
+			log.Printf("Container of task %v has vanished, marking the task as failed\n", id)
+			t.TryTransition(task.Failed, "container has vanished")
+			w.Ports.Release(t.ID)
+			t.EndTime = time.Now().UTC()
+			w.saveTask(t)
+		}
+	}
+
+	// the ports of the tasks which are still running are taken
+	for id, t := range w.DB {
+		if !active(t.State) {
+			continue
+		}
+		for p, bs := range t.HostPort {
+			for _, b := range bs {
+				if b.HostPort == "" {
+					continue
+				}
+				if err := w.Ports.Reserve(id, p.Proto(), b.HostPort); err != nil {
+					log.Printf("Error in reserving the ports of task %v: %v\n", id, err)
+				}
+			}
+		}
+	}
+}
+
+// whether the worker is still busy with the task, or its container should be running
+func active(s task.State) bool {
+	switch s {
+	case task.Scheduled, task.Pulling, task.Starting, task.Running, task.Stopping, task.Restarting:
+		return true
+	}
+	return false
+}
+
+// every runtime of the worker once, the default one under the empty name
+func (w *Worker) allRuntimes() map[string]task.Runtime {
+	runtimes := map[string]task.Runtime{"": w.Runtime}
+	for name, rt := range w.Runtimes {
+		if rt != w.Runtime {
+			runtimes[name] = rt
+		}
+	}
+	return runtimes
+}
+
+// the name under which allRuntimes lists the runtime of a task
+func (w *Worker) runtimeName(name string) string {
+	if rt, ok := w.Runtimes[name]; ok && rt == w.Runtime {
+		return ""
+	}
+	return name
+}
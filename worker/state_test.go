@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/store"
+	"github.com/hanshal101/core/task"
+)
+
+func TestReconcile(t *testing.T) {
+	s := store.NewMemory()
+	rt := task.NewFake()
+	w := New("w1", "127.0.0.1:1", nil, s, rt)
+	web := run(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled, ExposedPorts: nat.PortSet{"80/tcp": {}}})
+	gone := run(t, w, task.Task{ID: uuid.New(), Name: "gone", Image: "nginx", State: task.Scheduled, ExposedPorts: nat.PortSet{"80/tcp": {}}})
+	job := run(t, w, task.Task{ID: uuid.New(), Name: "job", Image: "busybox", State: task.Scheduled})
+	// a container the worker lost track of, its task was never stored
+	lost := task.Task{ID: uuid.New(), Name: "lost", Image: "redis"}
+	if err := rt.Pull(context.Background(), lost.Image); err != nil {
+		t.Fatal(err)
+	}
+	id, err := rt.Create(context.Background(), task.NewConfig(&lost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Start(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+
+	// while the worker is down one container vanishes and another one exits
+	if err := rt.Remove(context.Background(), gone.ContainerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Exit(job.ContainerID, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	again := New("w1", "127.0.0.1:1", nil, s, rt)
+	if err := again.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	again.Reconcile()
+
+	tests := []struct {
+		name      string
+		id        uuid.UUID
+		state     task.State
+		container string
+	}{
+		{"running", web.ID, task.Running, web.ContainerID},
+		{"vanished", gone.ID, task.Failed, gone.ContainerID},
+		{"exited", job.ID, task.Failed, job.ContainerID},
+		{"adopted", lost.ID, task.Running, id},
+	}
+	for _, tt := range tests {
+		got, ok := again.GetTask(tt.id)
+		if !ok {
+			t.Errorf("%s: task is not in the db", tt.name)
+			continue
+		}
+		if got.State != tt.state || got.ContainerID != tt.container {
+			t.Errorf("%s: task is %v with container %s, want %v with %s", tt.name, got.State, got.ContainerID, tt.state, tt.container)
+		}
+		var stored task.Task
+		if err := s.Get(tasksBucket, tt.id.String(), &stored); err != nil || stored.State != tt.state {
+			t.Errorf("%s: stored task is %v, %v", tt.name, stored.State, err)
+		}
+	}
+	if got, _ := again.GetTask(job.ID); got.ExitCode != 4 || got.EndTime.IsZero() {
+		t.Errorf("exited task has exit code %d and ended at %v", got.ExitCode, got.EndTime)
+	}
+	if got, _ := again.GetTask(gone.ID); got.EndTime.IsZero() {
+		t.Error("end time of the vanished task isn't set")
+	}
+
+	// the running task holds its host port again, the others gave theirs back
+	port := web.HostPort["80/tcp"][0].HostPort
+	allocations := again.Ports.Allocations()
+	if holder := allocations[portKey("tcp", port)]; holder != web.ID {
+		t.Errorf("host port %s of the running task is held by %v", port, holder)
+	}
+	if len(allocations) != 1 {
+		t.Errorf("allocations %v, want only the port of the running task", allocations)
+	}
+	if err := again.Ports.Reserve(uuid.New(), "tcp", port); err == nil {
+		t.Error("another task got the host port of the running task")
+	}
+}
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/hanshal101/core/task"
+)
+
+// creates the named volumes of the task which don't exist yet
+// they are labelled with the task, that's how removeVolumes knows which ones belong to it
+func (w *Worker) createVolumes(ctx context.Context, rt task.Runtime, t task.Task) error {
+	for _, m := range t.Volumes() {
+		if _, err := rt.InspectVolume(ctx, m.Source); err == nil {
+			continue
+		}
+		labels := map[string]string{task.LabelTaskID: t.ID.String()}
+		if err := rt.CreateVolume(ctx, m.Source, labels); err != nil {
+			return fmt.Errorf("error in creating the volume %s: %v", m.Source, err)
+		}
+		log.Printf("Created the volume %s for task %v\n", m.Source, t.ID)
+	}
+	return nil
+}
+
+// removes the volumes which were created for the task, unless the task wants to keep them
+// it runs once the container is gone, so it has to work even when the start was cancelled
+func (w *Worker) removeVolumes(rt task.Runtime, t task.Task) {
+	ctx := context.Background()
+	for _, m := range t.Volumes() {
+		if m.Keep {
+			continue
+		}
+		v, err := rt.InspectVolume(ctx, m.Source)
+		if err != nil || v.Labels[task.LabelTaskID] != t.ID.String() {
+			continue
+		}
+		if err := rt.RemoveVolume(ctx, m.Source); err != nil {
+			log.Printf("Error in removing the volume %s of task %v: %v\n", m.Source, t.ID, err)
+			continue
+		}
+		log.Printf("Removed the volume %s of task %v\n", m.Source, t.ID)
+	}
+}
+
+// removes a volume of the default runtime, the manager does this when a persistent volume is deleted
+func (a *API) DeleteVolume(c *gin.Context) {
+	name := c.Param("name")
+	ctx := c.Request.Context()
+	if _, err := a.Worker.Runtime.InspectVolume(ctx, name); err != nil {
+		msg := fmt.Sprintf("volume %s does not exist", name)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
+	}
+	if err := a.Worker.Runtime.RemoveVolume(ctx, name); err != nil {
+		log.Printf("Error in removing the volume %s: %v\n", name, err)
+		c.JSON(http.StatusConflict, ErrResponse{HTTPStatusCode: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	log.Printf("Removed the volume %s\n", name)
+	c.Status(http.StatusNoContent)
+}
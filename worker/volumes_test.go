@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/task"
+)
+
+func TestKeptVolumesSurviveTheTask(t *testing.T) {
+	w, rt := newTestWorker()
+	// there before the task, so it isn't the task's to remove
+	if err := rt.CreateVolume(context.Background(), "shared", nil); err != nil {
+		t.Fatal(err)
+	}
+	tk := run(t, w, task.Task{ID: uuid.New(), Name: "db", Image: "postgres", State: task.Scheduled, Mounts: []task.Mount{
+		{Type: task.MountVolume, Source: "scratch", Target: "/tmp/scratch"},
+		{Type: task.MountVolume, Source: "data", Target: "/var/lib/postgresql/data", Keep: true},
+		{Type: task.MountVolume, Source: "shared", Target: "/shared"},
+	}})
+	for _, name := range []string{"scratch", "data", "shared"} {
+		if _, err := rt.InspectVolume(context.Background(), name); err != nil {
+			t.Fatalf("volume %s of the running task: %v", name, err)
+		}
+	}
+	if v, _ := rt.InspectVolume(context.Background(), "data"); v.Labels[task.LabelTaskID] != tk.ID.String() {
+		t.Errorf("created volume is labelled %v", v.Labels)
+	}
+
+	stop := tk
+	stop.State = task.Completed
+	run(t, w, stop)
+
+	tests := []struct {
+		volume string
+		kept   bool
+	}{
+		{"scratch", false},
+		{"data", true},
+		{"shared", true},
+	}
+	for _, tt := range tests {
+		_, err := rt.InspectVolume(context.Background(), tt.volume)
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("volume %s kept %v, want %v", tt.volume, kept, tt.kept)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/c9s/goprocinfo/linux"
@@ -13,22 +16,66 @@ import (
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 
+	"github.com/hanshal101/core/store"
 	"github.com/hanshal101/core/task"
 )
 
 // this is a worker model
-// so it has following duties to do: run containers, accept task from manager, provide stats and keep track of tasks state
-// for running containers and keeping track of the state we can store it on map which can then implemented to etcd
-// since we would implement the task in a queue(FIFO) we would do this with normal golang-collections library
-// at last keeping the count of the task in the queue as task-count
+// it accepts tasks from the manager, runs them, keeps track of their state and provides stats
 type Worker struct {
-	Name      string
-	Queue     queue.Queue
-	DB        map[uuid.UUID]*task.Task
+	Name string
+	// where the worker api can be reached, managers are the addresses of the manager replicas it registers with
+	Address  string
+	Managers []string
+	manager  int
+	// the api and the loops share the queue, the db and the stats, docker is never called while holding mu
+	mu sync.Mutex
+	// adding a task signals notify, so the queue is worked off right away by MaxConcurrency goroutines
+	notify chan struct{}
+	// the starts and stops in flight, so that a stop can cancel a start which is still pulling its image
+	inflight       map[uuid.UUID]inflight
+	MaxConcurrency int
+	Queue          queue.Queue
+	// written through to Store so that the worker still knows its tasks after a restart
+	DB    map[uuid.UUID]*task.Task
+	Store store.Store
+	// a task runs on Runtime unless it names one of the Runtimes (e.g. task.RuntimeProcess)
+	Runtime  task.Runtime
+	Runtimes map[string]task.Runtime
+	// a task holds its host ports until it is completed or has failed
+	Ports *PortAllocator
+	// the health and readiness checks of the running tasks, by container
+	probes    map[string]*probes
 	TaskCount int
 	Stats     *Stats
 }
 
+// the store can be nil, then nothing survives a restart
+// rt runs the containers, e.g. task.NewDocker() or task.NewFake() when there is no docker daemon
+func New(name string, address string, managers []string, s store.Store, rt task.Runtime) *Worker {
+	return &Worker{
+		Name:           name,
+		Address:        address,
+		Managers:       managers,
+		Runtime:        rt,
+		Runtimes:       make(map[string]task.Runtime),
+		Ports:          NewPortAllocator(DefaultPortRangeStart, DefaultPortRangeEnd),
+		notify:         make(chan struct{}, 1),
+		inflight:       make(map[uuid.UUID]inflight),
+		probes:         make(map[string]*probes),
+		MaxConcurrency: 10,
+		Queue:          *queue.New(),
+		DB:             make(map[uuid.UUID]*task.Task),
+		Store:          s,
+	}
+}
+
+// a start or stop of a task which is running right now
+type inflight struct {
+	state  task.State
+	cancel context.CancelFunc
+}
+
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
@@ -41,51 +88,116 @@ type ErrResponse struct {
 func (w *Worker) CollectStats() {
 	for {
 		log.Println("Collecting Stats")
-		w.Stats = GetStats()
-		w.TaskCount = w.Stats.TaskCount
+		stats := GetStats()
+		w.mu.Lock()
+		w.TaskCount = w.runningTasks()
+		stats.TaskCount = w.TaskCount
+		w.Stats = stats
+		w.mu.Unlock()
 		time.Sleep(10 * time.Second)
 	}
 }
 
 // this is diff from StartTask
 // as this is responsible for identifying the task’s current state and then either starting or stopping
+// it starts MaxConcurrency goroutines which pick tasks off the queue as soon as they are added, the ticker is only a fallback
 func (w *Worker) RunTasks() {
+	var wg sync.WaitGroup
+	for i := 0; i < max(w.MaxConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.runLoop()
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) runLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 	for {
-		if w.Queue.Len() != 0 {
+		select {
+		case <-w.notify:
+		case <-ticker.C:
+		}
+		// only what is queued right now, tasks which are put back wait for the next signal
+		for n := w.pending(); n > 0; n-- {
 			result := w.runTask()
 			if result.Error != nil {
 				log.Printf("error running tasks: %v", result.Error)
 			}
-		} else {
-			log.Println("no tasks in the queue")
 		}
-		log.Println("Sleeping for 5 seconds")
-		time.Sleep(5 * time.Second)
 	}
 }
 
+// wakes up one of the goroutines of RunTasks, a pending signal is enough so this never blocks
+func (w *Worker) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns the number of tasks waiting in the queue
+func (w *Worker) pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Queue.Len()
+}
+
 func (w *Worker) runTask() task.DockerResult {
+	w.mu.Lock()
 	t := w.Queue.Dequeue()
 	if t == nil {
+		w.mu.Unlock()
 		log.Println("No tasks in the queue")
 		return task.DockerResult{Error: nil}
 	}
+	// there is more work, so wake up another goroutine of the pool
+	if w.Queue.Len() > 0 {
+		w.signal()
+	}
 
 	taskQueued := t.(task.Task)
-	taskPersisted := w.DB[taskQueued.ID]
-	if taskPersisted == nil {
-		taskPersisted = &taskQueued
-		w.DB[taskQueued.ID] = &taskQueued
+	// the task is being started or stopped already, it is picked up again once that is done
+	if _, ok := w.inflight[taskQueued.ID]; ok {
+		w.Queue.Enqueue(taskQueued)
+		w.mu.Unlock()
+		return task.DockerResult{Error: nil}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.inflight[taskQueued.ID] = inflight{state: taskQueued.State, cancel: cancel}
+	defer w.finish(taskQueued.ID)
+
+	persisted := w.DB[taskQueued.ID]
+	if persisted == nil {
+		// a copy, taskQueued is changed below without the lock
+		c := taskQueued
+		persisted = &c
+		w.DB[c.ID] = &c
+		w.saveTask(&c)
 	}
+	// copy it, the db entry may change once the lock is released
+	taskPersisted := *persisted
+	w.mu.Unlock()
 
 	var result task.DockerResult
+	// the manager placed the task here again after it completed or failed, its old container has to go first
+	if taskQueued.State == task.Scheduled && (taskPersisted.State == task.Completed || taskPersisted.State == task.Failed) {
+		taskQueued.ContainerID = taskPersisted.ContainerID
+		return w.RestartTask(ctx, taskQueued)
+	}
 	if task.ValidStateTransitions(taskPersisted.State, taskQueued.State) {
 		switch taskQueued.State {
 		case task.Scheduled:
-			result = w.StartTask(taskQueued)
-			taskPersisted.ContainerID = result.ContainerID
+			result = w.StartTask(ctx, taskQueued)
+		case task.Restarting:
+			// the container which is still around is the one the worker knows about
+			taskQueued.ContainerID = taskPersisted.ContainerID
+			result = w.RestartTask(ctx, taskQueued)
 		case task.Completed:
-			result = w.StopTask(taskQueued)
+			result = w.StopTask(ctx, taskPersisted)
 		default:
 			result.Error = errors.New("we can't apply this")
 		}
@@ -97,51 +209,200 @@ func (w *Worker) runTask() task.DockerResult {
 	return result
 }
 
-func (w *Worker) StartTask(t task.Task) task.DockerResult {
+// cancels the start of the task if it is in flight, the start then leaves the task completed
+// returns false if the task isn't being started right now
+func (w *Worker) cancel(id uuid.UUID) bool {
+	op, ok := w.inflight[id]
+	if !ok || (op.state != task.Scheduled && op.state != task.Restarting) {
+		return false
+	}
+	log.Printf("Cancelling the start of task %v\n", id)
+	op.cancel()
+	return true
+}
+
+// the runtime the task asks for
+func (w *Worker) runtime(t task.Task) (task.Runtime, error) {
+	if t.Runtime == "" {
+		return w.Runtime, nil
+	}
+	rt, ok := w.Runtimes[t.Runtime]
+	if !ok {
+		return nil, fmt.Errorf("runtime %s is not available on worker %s", t.Runtime, w.Name)
+	}
+	return rt, nil
+}
+
+func (w *Worker) StartTask(ctx context.Context, t task.Task) task.DockerResult {
 	t.StartTime = time.Now().UTC()
+	// what is left of an earlier run of the task
+	t.EndTime = time.Time{}
+	t.ExitCode = 0
+	t.OOMKilled = false
+	t.Error = ""
+	t.Health = ""
+	t.HealthMessage = ""
+	t.Ready = false
+	t.ReadinessMessage = ""
 	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
 
-	result := d.Run()
+	rt, err := w.runtime(t)
+	if err != nil {
+		log.Printf("Error in Running the container %v: %v\n", config, err)
+		t.Error = err.Error()
+		t.TryTransition(task.Failed, err.Error())
+		w.setTask(&t)
+		return task.DockerResult{Error: err}
+	}
+
+	bindings, err := w.bindPorts(t, config.PortBindings)
+	if err != nil {
+		log.Printf("Error in allocating the ports of %v: %v\n", config, err)
+		t.Error = fmt.Sprintf("error in allocating the ports: %v", err)
+		t.TryTransition(task.Failed, t.Error)
+		w.setTask(&t)
+		return task.DockerResult{Error: err}
+	}
+	config.PortBindings = bindings
+	t.HostPort = bindings
+
+	t.TryTransition(task.Pulling, fmt.Sprintf("pulling the image %s", t.Image))
+	w.setTask(&t)
+	result := task.DockerResult{}
+	if err := rt.Pull(ctx, config.Image); err != nil {
+		result.Error = fmt.Errorf("error in pulling the image %s: %v", config.Image, err)
+	} else {
+		t.TryTransition(task.Starting, "starting the container")
+		w.setTask(&t)
+		if err := w.createVolumes(ctx, rt, t); err != nil {
+			result.Error = err
+		} else {
+			result = task.StartContainer(ctx, rt, config)
+		}
+	}
+	// the task was stopped while it was starting
+	if ctx.Err() != nil {
+		if result.Error == nil {
+			task.StopContainer(context.Background(), rt, result.ContainerID)
+		}
+		log.Printf("Start of the container %v was cancelled\n", config)
+		w.Ports.Release(t.ID)
+		w.removeVolumes(rt, t)
+		t.EndTime = time.Now().UTC()
+		t.TryTransition(task.Completed, "stopped while it was starting")
+		w.setTask(&t)
+		return task.DockerResult{Error: nil, Action: "cancel", Result: "cancelled"}
+	}
 	if result.Error != nil {
-		log.Printf("Error in Running the container %v: %v\n", d.Config, result.Error)
-		t.State = task.Failed
-		w.DB[t.ID] = &t
+		log.Printf("Error in Running the container %v: %v\n", config, result.Error)
+		w.Ports.Release(t.ID)
+		w.removeVolumes(rt, t)
+		t.Error = result.Error.Error()
+		t.TryTransition(task.Failed, t.Error)
+		w.setTask(&t)
 		return result
 	}
 	t.ContainerID = result.ContainerID
-	t.State = task.Running
-	w.DB[t.ID] = &t
+	if t.HealthCheck.Active() {
+		t.Health = task.HealthStarting
+	}
+	// without a readiness check a task is ready as soon as it runs
+	t.Ready = !t.ReadinessCheck.Active()
+	t.TryTransition(task.Running, fmt.Sprintf("container %s is running", result.ContainerID))
+	w.setTask(&t)
 
-	log.Printf("Running the container %v: %v\n", d.Config, &t)
+	log.Printf("Running the container %v: %v\n", config, &t)
 	return result
 }
 
-func (w *Worker) StopTask(t task.Task) task.DockerResult {
-	fmt.Println("cid----------------> ", t.ContainerID)
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
+// gets rid of the old container of the task, if it is still around, and starts a new one
+func (w *Worker) RestartTask(ctx context.Context, t task.Task) task.DockerResult {
+	if t.ContainerID != "" {
+		rt, err := w.runtime(t)
+		if err != nil {
+			t.TryTransition(task.Failed, err.Error())
+			w.setTask(&t)
+			return task.DockerResult{Error: err}
+		}
+		// the container may be gone already, which is fine as well
+		task.StopContainer(ctx, rt, t.ContainerID)
+		w.Ports.Release(t.ID)
+		t.ContainerID = ""
+	}
+	return w.StartTask(ctx, t)
+}
 
-	result := d.Stop(t.ContainerID)
+func (w *Worker) StopTask(ctx context.Context, t task.Task) task.DockerResult {
+	fmt.Println("cid----------------> ", t.ContainerID)
+	rt, err := w.runtime(t)
+	if err != nil {
+		return task.DockerResult{Error: err}
+	}
+	t.TryTransition(task.Stopping, "stop requested")
+	w.setTask(&t)
+	result := task.StopContainer(ctx, rt, t.ContainerID)
+	w.Ports.Release(t.ID)
 	if result.Error != nil {
-		log.Printf("Error in Stopping the container %v: %v\n", d.Config, result.Error)
-		t.State = task.Failed
-		w.DB[t.ID] = &t
+		log.Printf("Error in Stopping the container %v: %v\n", t.ContainerID, result.Error)
+		t.Error = fmt.Sprintf("error in stopping the container: %v", result.Error)
+		t.TryTransition(task.Failed, t.Error)
+		w.setTask(&t)
 		return result
 	}
+	w.removeVolumes(rt, t)
 	t.EndTime = time.Now().UTC()
-	t.State = task.Completed
-	w.DB[t.ID] = &t
+	t.TryTransition(task.Completed, "stopped")
+	w.setTask(&t)
 
-	log.Printf("Stopped and removed the container %v: %v\n", d.Config, &t)
+	log.Printf("Stopped and removed the container %v: %v\n", t.ContainerID, &t)
 	return result
 }
 
+func (w *Worker) finish(id uuid.UUID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if op, ok := w.inflight[id]; ok {
+		op.cancel()
+	}
+	delete(w.inflight, id)
+	if w.Queue.Len() > 0 {
+		w.signal()
+	}
+}
+
+func (w *Worker) setTask(t *task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// the db gets its own copy, the caller keeps changing t without the lock
+	c := *t
+	w.DB[c.ID] = &c
+	w.saveTask(&c)
+}
+
+func (w *Worker) runningTasks() int {
+	count := 0
+	for _, t := range w.DB {
+		if t.State == task.Running {
+			count++
+		}
+	}
+	return count
+}
+
 func (w *Worker) AddTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// stopping a task which is still starting, the start cleans up after itself so there is nothing left to stop
+	if t.State == task.Completed && w.cancel(t.ID) {
+		return
+	}
 	w.Queue.Enqueue(t)
+	w.signal()
 }
 
 func (w *Worker) GetTasks() []task.Task {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	tasks := make([]task.Task, 0, len(w.DB))
 	for _, t := range w.DB {
 		tasks = append(tasks, *t)
@@ -150,10 +411,29 @@ func (w *Worker) GetTasks() []task.Task {
 	return tasks
 }
 
-func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	return d.Inspect(t.ContainerID)
+func (w *Worker) GetTask(id uuid.UUID) (task.Task, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.DB[id]
+	if !ok {
+		return task.Task{}, false
+	}
+	return *t, true
+}
+
+// the stats which were collected last, nil until the first collection
+func (w *Worker) CurrentStats() *Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Stats
+}
+
+func (w *Worker) InspectTask(t task.Task) (task.ContainerInfo, error) {
+	rt, err := w.runtime(t)
+	if err != nil {
+		return task.ContainerInfo{}, err
+	}
+	return rt.Inspect(context.Background(), t.ContainerID)
 }
 
 func (w *Worker) UpdateTasks() {
@@ -167,23 +447,64 @@ func (w *Worker) UpdateTasks() {
 }
 
 func (w *Worker) updateTasks() {
-	for id, t := range w.DB {
-		if t.State == task.Running {
-			resp := w.InspectTask(*t)
-			if resp.Error != nil {
-				log.Printf("Error: %v\n", resp.Error)
-			}
-			if resp.Container == nil {
-				log.Printf("No container found for running state")
-				w.DB[id].State = task.Failed
-			}
-			if resp.Container.State.Status == "exited" {
-				log.Printf("Container for task %v is exited", resp.Container)
-				w.DB[id].State = task.Failed
-			}
-			fmt.Println("hp----------------> ", resp.Container.NetworkSettings.Ports)
-			w.DB[id].HostPort = resp.Container.NetworkSettings.Ports
+	// inspect copies of the running tasks, so that docker is not called with the lock held
+	for _, t := range w.GetTasks() {
+		if t.State != task.Running {
+			continue
 		}
+		info, err := w.InspectTask(t)
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+		w.applyInspect(t.ID, info, err)
+	}
+}
+
+func (w *Worker) applyInspect(id uuid.UUID, info task.ContainerInfo, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// the task was stopped in the meantime
+	t, ok := w.DB[id]
+	if !ok || t.State != task.Running {
+		return
+	}
+	if err != nil {
+		log.Printf("No container found for running state")
+		t.TryTransition(task.Failed, fmt.Sprintf("error in inspecting the container: %v", err))
+		w.Ports.Release(t.ID)
+		w.saveTask(t)
+		return
+	}
+	if info.Status == "exited" {
+		log.Printf("Container for task %v is exited", info.ID)
+		w.exited(t, info)
+	}
+	fmt.Println("hp----------------> ", info.Ports)
+	t.HostPort = info.Ports
+	w.saveTask(t)
+}
+
+// the container of the task exited on its own, it only completed if it exited with 0
+// a container which was killed for running out of memory counts as failed, whatever its exit code
+func (w *Worker) exited(t *task.Task, info task.ContainerInfo) {
+	t.ExitCode = info.ExitCode
+	t.OOMKilled = info.OOMKilled
+	t.Error = info.Error
+	t.EndTime = info.FinishedAt
+	if t.EndTime.IsZero() {
+		t.EndTime = time.Now().UTC()
+	}
+	w.Ports.Release(t.ID)
+
+	switch {
+	case info.OOMKilled:
+		t.TryTransition(task.Failed, fmt.Sprintf("container ran out of memory and was killed (exit code %d)", info.ExitCode))
+	case info.ExitCode != 0 && info.Error != "":
+		t.TryTransition(task.Failed, fmt.Sprintf("container exited with code %d: %s", info.ExitCode, info.Error))
+	case info.ExitCode != 0:
+		t.TryTransition(task.Failed, fmt.Sprintf("container exited with code %d", info.ExitCode))
+	default:
+		t.TryTransition(task.Completed, "container exited with code 0")
 	}
 }
 
@@ -224,22 +545,18 @@ func (a *API) GetTasks(c *gin.Context) {
 }
 
 func (a *API) GetTasksbyID(c *gin.Context) {
-	tasks := a.Worker.GetTasks()
-	if len(tasks) == 0 {
-		c.JSON(http.StatusOK, []interface{}{})
-		return
-	}
 	tID := c.Param("taskID")
 	taskID, err := uuid.Parse(tID)
 	if err != nil {
 		fmt.Println("Error parsing UUID:", err)
+		c.JSON(http.StatusBadRequest, ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: err.Error()})
 		return
 	}
-	var t task.Task
-	for _, tk := range tasks {
-		if tk.ID == taskID {
-			t = tk
-		}
+	t, ok := a.Worker.GetTask(taskID)
+	if !ok {
+		msg := fmt.Sprintf("task %v does not exist", taskID)
+		c.JSON(http.StatusNotFound, ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: msg})
+		return
 	}
 	c.JSON(http.StatusOK, t)
 }
@@ -248,13 +565,13 @@ func (a *API) DeleteTask(c *gin.Context) {
 	tID := c.Param("taskID")
 	utID, _ := uuid.Parse(tID)
 
-	_, ok := a.Worker.DB[utID]
+	taskToStop, ok := a.Worker.GetTask(utID)
 	if !ok {
 		log.Printf("task does not exists, uuid: %v", utID)
 		c.Status(http.StatusNotFound)
+		return
 	}
-	taskToStop := a.Worker.DB[utID]
-	taskCopy := *taskToStop
+	taskCopy := taskToStop
 	taskCopy.State = task.Completed
 	a.Worker.AddTask(taskCopy)
 
@@ -271,6 +588,12 @@ func (a *API) InitRouter() {
 
 	// Stats
 	a.Router.GET("/stats", a.GetStatsHandler)
+
+	// host ports handed out to the tasks
+	a.Router.GET("/ports", a.GetPorts)
+
+	// volumes
+	a.Router.DELETE("/volumes/:name", a.DeleteVolume)
 }
 
 func (a *API) Start() {
@@ -278,8 +601,12 @@ func (a *API) Start() {
 	a.Router.Run(fmt.Sprintf("%s:%v", a.Address, a.Port))
 }
 
+func (a *API) GetPorts(c *gin.Context) {
+	c.JSON(http.StatusOK, a.Worker.Ports.Allocations())
+}
+
 func (a *API) GetStatsHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, a.Worker.Stats)
+	c.JSON(http.StatusOK, a.Worker.CurrentStats())
 }
 
 // Worker Metrics
@@ -288,6 +615,7 @@ type Stats struct {
 	MemoryStats *linux.MemInfo
 	DiskStats   *linux.Disk
 	LoadStats   *linux.LoadAvg
+	Cores       int
 	TaskCount   int
 }
 
@@ -384,5 +712,6 @@ func GetStats() *Stats {
 		MemoryStats: GetMemoryInfo(),
 		DiskStats:   GetDiskInfo(),
 		LoadStats:   GetLoadAverage(),
+		Cores:       runtime.NumCPU(),
 	}
 }
@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/hanshal101/core/internal/stresstest"
+	"github.com/hanshal101/core/task"
+)
+
+func newTestWorker() (*Worker, *task.Fake) {
+	rt := task.NewFake()
+	return New("w1", "127.0.0.1:1", nil, nil, rt), rt
+}
+
+// queues the task and runs it right away, like one of the goroutines of RunTasks would
+func run(t *testing.T, w *Worker, tk task.Task) task.Task {
+	t.Helper()
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("error in running task %s: %v", tk.Name, result.Error)
+	}
+	got, ok := w.GetTask(tk.ID)
+	if !ok {
+		t.Fatalf("task %s is not in the db", tk.Name)
+	}
+	return got
+}
+
+func TestStartTask(t *testing.T) {
+	w, rt := newTestWorker()
+	tk := run(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled})
+
+	if tk.State != task.Running || tk.ContainerID == "" || !tk.Ready {
+		t.Fatalf("started task is %v with container %q, ready %v", tk.State, tk.ContainerID, tk.Ready)
+	}
+	info, err := rt.Inspect(context.Background(), tk.ContainerID)
+	if err != nil || info.Status != "running" {
+		t.Errorf("container is %q, %v", info.Status, err)
+	}
+	want := []task.State{task.Pulling, task.Starting, task.Running}
+	if len(tk.History) != len(want) {
+		t.Fatalf("history %+v", tk.History)
+	}
+	for i, s := range want {
+		if tk.History[i].To != s {
+			t.Errorf("transition %d goes to %v, want %v", i, tk.History[i].To, s)
+		}
+	}
+	if n := w.runningTasks(); n != 1 {
+		t.Errorf("%d running tasks, want 1", n)
+	}
+}
+
+func TestStartTaskPullError(t *testing.T) {
+	w, rt := newTestWorker()
+	rt.PullErrors["missing"] = errors.New("manifest unknown")
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "missing", State: task.Scheduled}
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error == nil {
+		t.Fatal("start of a task whose image can't be pulled succeeded")
+	}
+	got, _ := w.GetTask(tk.ID)
+	if got.State != task.Failed || got.Error == "" {
+		t.Errorf("task is %v with error %q, want failed", got.State, got.Error)
+	}
+}
+
+func TestContainerExits(t *testing.T) {
+	tests := []struct {
+		name      string
+		exit      func(rt *task.Fake, id string) error
+		state     task.State
+		exitCode  int
+		oomKilled bool
+	}{
+		{"finished", func(rt *task.Fake, id string) error { return rt.Exit(id, 0) }, task.Completed, 0, false},
+		{"crashed", func(rt *task.Fake, id string) error { return rt.Exit(id, 3) }, task.Failed, 3, false},
+		{"out of memory", func(rt *task.Fake, id string) error { return rt.OOMKill(id) }, task.Failed, 137, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker()
+			tk := run(t, w, task.Task{ID: uuid.New(), Name: "job", Image: "busybox", State: task.Scheduled})
+			if err := tt.exit(rt, tk.ContainerID); err != nil {
+				t.Fatal(err)
+			}
+			w.updateTasks()
+
+			got, _ := w.GetTask(tk.ID)
+			if got.State != tt.state || got.ExitCode != tt.exitCode || got.OOMKilled != tt.oomKilled {
+				t.Errorf("task is %v with exit code %d, oom killed %v, want %v, %d, %v", got.State, got.ExitCode, got.OOMKilled, tt.state, tt.exitCode, tt.oomKilled)
+			}
+			if got.EndTime.IsZero() {
+				t.Error("end time of the task isn't set")
+			}
+			if n := w.runningTasks(); n != 0 {
+				t.Errorf("%d running tasks, want 0", n)
+			}
+		})
+	}
+}
+
+func TestStopTask(t *testing.T) {
+	w, rt := newTestWorker()
+	tk := run(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled})
+
+	stop := tk
+	stop.State = task.Completed
+	got := run(t, w, stop)
+	if got.State != task.Completed {
+		t.Errorf("stopped task is %v, want completed", got.State)
+	}
+	if info, err := rt.Inspect(context.Background(), tk.ContainerID); err == nil && info.Status == "running" {
+		t.Error("container of the stopped task is still running")
+	}
+}
+
+// hits the api from many clients while the loops of the worker run, it is meant to be run with -race
+func TestConcurrentAPIAndLoops(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w, rt := newTestWorker()
+	w.MaxConcurrency = 4
+	go w.RunTasks()
+	go w.CheckHealth()
+	go w.UpdateTasks()
+	go w.CollectStats()
+
+	a := API{Worker: w, Router: gin.New()}
+	a.InitRouter()
+	s := httptest.NewServer(a.Router)
+	defer s.Close()
+
+	do := func(method, path string, body []byte, want ...int) {
+		stresstest.Do(t, method, s.URL+path, body, want...)
+	}
+	// containers exit on their own now and then
+	exit := func() {
+		for _, tk := range w.GetTasks() {
+			if tk.State == task.Running && tk.ContainerID != "" {
+				rt.Exit(tk.ContainerID, 1)
+				break
+			}
+		}
+	}
+	// the loops only come around every few seconds, so their passes are run back to back as well
+	stresstest.Run(8, 10, func(c, i int) {
+		tk := task.Task{ID: uuid.New(), Name: fmt.Sprintf("t%d-%d", c, i), Image: "busybox", State: task.Scheduled}
+		if i%2 == 0 {
+			tk.HealthCheck = &task.HealthCheck{Type: task.HealthExec, Command: []string{"true"}, IntervalSeconds: 1}
+		}
+		data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: tk})
+		do(http.MethodPost, "/tasks", data, http.StatusCreated)
+		do(http.MethodGet, "/tasks", nil, http.StatusOK)
+		// the task shows up once one of the goroutines of the pool picked it up
+		do(http.MethodGet, "/tasks/"+tk.ID.String(), nil, http.StatusOK, http.StatusNotFound)
+		do(http.MethodGet, "/stats", nil, http.StatusOK)
+		if i%3 == 0 {
+			do(http.MethodDelete, "/tasks/"+tk.ID.String(), nil, http.StatusNoContent, http.StatusNotFound)
+		}
+	}, w.updateTasks, w.checkHealth, exit)
+
+	for _, tk := range w.GetTasks() {
+		if tk.State == task.Pending {
+			t.Errorf("task %s was never started", tk.Name)
+		}
+	}
+}